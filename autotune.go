@@ -0,0 +1,274 @@
+package cl11
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// An Autotuner finds the fastest work-group size for launches of a kernel and
+// remembers it.
+//
+// Results are keyed by kernel name, device name, driver version and global
+// size. If Path is set results are loaded from and saved to a JSON file so
+// they persist across processes. Setting a command queue's Autotuner makes
+// EnqueueNDRangeKernel use the tuned size when localSize is omitted.
+type Autotuner struct {
+
+	// The JSON file results are persisted to, if not empty.
+	Path string
+
+	// The number of timed launches per candidate work-group size. Defaults to
+	// 5 if zero.
+	Iterations int
+
+	mu      sync.Mutex
+	results map[string][]int
+}
+
+const defaultAutotuneIterations = 5
+
+// Creates an autotuner persisted to path, loading any existing results. A
+// missing file is not an error.
+func LoadAutotuner(path string) (*Autotuner, error) {
+
+	a := &Autotuner{Path: path, results: make(map[string][]int)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &a.results)
+	if err != nil {
+		return nil, fmt.Errorf("cl: LoadAutotuner: %s: %s", path, err)
+	}
+
+	return a, nil
+}
+
+// Returns the tuned work-group size for a launch, if there is one.
+func (a *Autotuner) LocalSize(d *Device, k *Kernel, globalSize []int) ([]int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	localSize, ok := a.results[autotuneKey(d, k, globalSize)]
+	return localSize, ok
+}
+
+// Benchmarks the candidate work-group sizes for launching the kernel over
+// globalSize with args, records the fastest and returns it.
+//
+// The command queue must have been created with QueueProfilingEnable. The
+// kernel arguments are set to args and the kernel is launched several times
+// per candidate, so any output buffers will be overwritten. If Path is set the
+// results are saved.
+func (a *Autotuner) Tune(cq *CommandQueue, k *Kernel, args []interface{}, globalSize []int) ([]int, error) {
+
+	if cq.Properties&QueueProfilingEnable == 0 {
+		return nil, ProfilingInfoNotAvailable
+	}
+
+	err := k.SetArguments(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := a.Iterations
+	if iterations <= 0 {
+		iterations = defaultAutotuneIterations
+	}
+
+	var best []int
+	var bestTime int64
+	for _, candidate := range autotuneCandidates(cq.Device, k, globalSize) {
+
+		elapsed, err := timeLaunches(cq, k, globalSize, candidate, iterations)
+		if err != nil {
+			// Not every candidate is valid for every kernel (e.g. it uses too
+			// many resources), try the next one.
+			continue
+		}
+
+		if best == nil || elapsed < bestTime {
+			best = candidate
+			bestTime = elapsed
+		}
+	}
+	if best == nil {
+		return nil, InvalidWorkGroupSize
+	}
+
+	a.mu.Lock()
+	if a.results == nil {
+		a.results = make(map[string][]int)
+	}
+	a.results[autotuneKey(cq.Device, k, globalSize)] = best
+	a.mu.Unlock()
+
+	if a.Path != "" {
+		err = a.Save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return best, nil
+}
+
+// Writes the results to Path. The results already in the file, such as those
+// saved by other processes, are merged in first, with the autotuner's own
+// taking precedence. The file is replaced atomically so concurrent processes
+// never read a partially written file.
+func (a *Autotuner) Save() error {
+
+	saved := make(map[string][]int)
+	data, err := ioutil.ReadFile(a.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		// A file that can not be parsed is replaced, as LoadAutotuner would
+		// refuse it anyway.
+		if json.Unmarshal(data, &saved) != nil {
+			saved = make(map[string][]int)
+		}
+	}
+
+	a.mu.Lock()
+	if a.results == nil {
+		a.results = make(map[string][]int)
+	}
+	for key, localSize := range saved {
+		if _, ok := a.results[key]; !ok {
+			a.results[key] = localSize
+		}
+	}
+	data, err = json.MarshalIndent(a.results, "", "\t")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(a.Path, data)
+}
+
+// Launches the kernel a number of times and returns the total device time in
+// nanoseconds.
+func timeLaunches(cq *CommandQueue, k *Kernel, globalSize, localSize []int, iterations int) (int64, error) {
+
+	// An untimed launch to warm caches and trigger any lazy compilation.
+	err := cq.EnqueueNDRangeKernel(k, nil, globalSize, localSize, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	events := make([]Event, iterations)
+	for i := range events {
+		err = cq.EnqueueNDRangeKernel(k, nil, globalSize, localSize, nil, &events[i])
+		if err != nil {
+			for j := 0; j < i; j++ {
+				events[j].Release()
+			}
+			return 0, err
+		}
+	}
+
+	err = cq.Finish()
+
+	var total int64
+	for i := range events {
+		if err == nil {
+			err = events[i].GetProfilingInfo()
+			total += events[i].End - events[i].Start
+		}
+		events[i].Release()
+	}
+
+	return total, err
+}
+
+// The work-group sizes to try: every combination of power of two divisors of
+// the global size that the device and kernel allow.
+func autotuneCandidates(d *Device, k *Kernel, globalSize []int) [][]int {
+
+	maxGroup := d.MaxWorkGroupSize
+	for i := range k.WorkGroupInfo {
+		if k.WorkGroupInfo[i].Device == d && k.WorkGroupInfo[i].WorkGroupSize > 0 &&
+			k.WorkGroupInfo[i].WorkGroupSize < maxGroup {
+			maxGroup = k.WorkGroupInfo[i].WorkGroupSize
+		}
+	}
+
+	perDim := make([][]int, len(globalSize))
+	for i, size := range globalSize {
+		maxItems := maxGroup
+		if i < len(d.MaxWorkItemSizes) && d.MaxWorkItemSizes[i] < maxItems {
+			maxItems = d.MaxWorkItemSizes[i]
+		}
+		for n := 1; n <= size && n <= maxItems; n *= 2 {
+			if size%n == 0 {
+				perDim[i] = append(perDim[i], n)
+			}
+		}
+	}
+
+	var candidates [][]int
+	var walk func(dim, product int, current []int)
+	walk = func(dim, product int, current []int) {
+		if dim == len(perDim) {
+			candidates = append(candidates, append([]int(nil), current...))
+			return
+		}
+		for _, n := range perDim[dim] {
+			if product*n > maxGroup {
+				break
+			}
+			walk(dim+1, product*n, append(current, n))
+		}
+	}
+	walk(0, 1, nil)
+
+	return candidates
+}
+
+func autotuneKey(d *Device, k *Kernel, globalSize []int) string {
+	return fmt.Sprintf("%s|%s|%s|%v", k.FunctionName, d.Name, d.DriverVersion, globalSize)
+}
+
+// Writes data to a temporary file in the same directory and renames it over
+// name. The file keeps the mode of the one it replaces, or is readable by
+// everyone if it is new.
+func writeFileAtomic(name string, data []byte) error {
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		// TempFile creates the file readable only by its owner.
+		err = f.Chmod(mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package cl11
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAutotuneCandidates(t *testing.T) {
+	d := &Device{Name: "test", MaxWorkGroupSize: 256, MaxWorkItemSizes: []int{256, 256, 64}}
+	k := &Kernel{FunctionName: "k", WorkGroupInfo: []KernelWorkGroupInfo{{Device: d, WorkGroupSize: 8}}}
+
+	got := autotuneCandidates(d, k, []int{12, 4})
+	want := [][]int{{1, 1}, {1, 2}, {1, 4}, {2, 1}, {2, 2}, {2, 4}, {4, 1}, {4, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAutotunerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cl11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tuned.json")
+
+	d := &Device{Name: "test", DriverVersion: Version{Major: 1, Minor: 2}}
+	k := &Kernel{FunctionName: "k"}
+
+	a, err := LoadAutotuner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.LocalSize(d, k, []int{64}); ok {
+		t.Error("unexpected result in empty autotuner")
+	}
+
+	a.results[autotuneKey(d, k, []int{64})] = []int{16}
+	err = a.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = LoadAutotuner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localSize, ok := a.LocalSize(d, k, []int{64})
+	if !ok || !reflect.DeepEqual(localSize, []int{16}) {
+		t.Error("expected [16], got", localSize, ok)
+	}
+	if _, ok := a.LocalSize(d, k, []int{128}); ok {
+		t.Error("unexpected result for different global size")
+	}
+}
+
+func TestAutotunerSaveMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cl11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tuned.json")
+
+	d := &Device{Name: "test"}
+	k := &Kernel{FunctionName: "k"}
+
+	// Two processes that loaded the file before either saved.
+	first, err := LoadAutotuner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadAutotuner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.results[autotuneKey(d, k, []int{64})] = []int{16}
+	err = first.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.results[autotuneKey(d, k, []int{128})] = []int{32}
+	err = second.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := LoadAutotuner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ global, want []int }{{[]int{64}, []int{16}}, {[]int{128}, []int{32}}} {
+		localSize, ok := a.LocalSize(d, k, test.global)
+		if !ok || !reflect.DeepEqual(localSize, test.want) {
+			t.Error("expected", test.want, "for", test.global, "got", localSize, ok)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("got mode %v, want %v", mode, os.FileMode(0644))
+	}
+}
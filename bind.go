@@ -0,0 +1,180 @@
+package cl11
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/rdwilliamson/cl11/internal/signature"
+)
+
+var (
+	commandQueueType = reflect.TypeOf((*CommandQueue)(nil))
+	intSliceType     = reflect.TypeOf([]int(nil))
+	eventPtrType     = reflect.TypeOf((*Event)(nil))
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	bufferPtrType    = reflect.TypeOf((*Buffer)(nil))
+	imagePtrType     = reflect.TypeOf((*Image)(nil))
+	samplerPtrType   = reflect.TypeOf((*Sampler)(nil))
+	localSpaceType   = reflect.TypeOf(LocalSpaceArg(0))
+)
+
+// Binds a kernel to a Go function variable so the kernel can be launched like a
+// regular function call.
+//
+// FnPtr must be a pointer to a function variable of the form
+//
+//	func(q *CommandQueue, global []int, args...) (*Event, error)
+//
+// where args are the kernel arguments, in order, of any type accepted by
+// SetArg. Calling the function sets the arguments and enqueues the kernel over
+// the global range, letting the implementation pick the work-group size. The
+// returned event must be released by the caller.
+//
+// The parameters are checked against the kernel's argument count, and against
+// the kernel's declaration if the program was created from source, when
+// BindKernel is called. As with SetArg the bound function must not be called
+// concurrently, bind a Kernel.Clone per goroutine instead.
+func BindKernel(k *Kernel, fnPtr interface{}) error {
+
+	ptr := reflect.ValueOf(fnPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("cl: BindKernel: expected pointer to function, got %T", fnPtr)
+	}
+	fn := ptr.Elem()
+	fnType := fn.Type()
+
+	if fnType.NumIn() < 2 || fnType.In(0) != commandQueueType || fnType.In(1) != intSliceType ||
+		fnType.IsVariadic() {
+		return fmt.Errorf("cl: BindKernel: %s must start with (*CommandQueue, []int)", fnType)
+	}
+	if fnType.NumOut() != 2 || fnType.Out(0) != eventPtrType || fnType.Out(1) != errorType {
+		return fmt.Errorf("cl: BindKernel: %s must return (*Event, error)", fnType)
+	}
+
+	numArgs := fnType.NumIn() - 2
+	if numArgs != k.Arguments {
+		return fmt.Errorf("cl: BindKernel: kernel %s takes %d arguments, %s takes %d", k.FunctionName, k.Arguments,
+			fnType, numArgs)
+	}
+
+	if signature := k.signature(); signature != nil && len(signature.Args) == numArgs {
+		for i := range signature.Args {
+			err := checkKernelArg(&signature.Args[i], fnType.In(i+2))
+			if err != nil {
+				return fmt.Errorf("cl: BindKernel: kernel %s argument %d (%s): %s", k.FunctionName, i,
+					signature.Args[i].Name, err)
+			}
+		}
+	}
+
+	fn.Set(reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+
+		result := func(e *Event, err error) []reflect.Value {
+			errValue := reflect.Zero(errorType)
+			if err != nil {
+				errValue = reflect.ValueOf(&err).Elem()
+			}
+			return []reflect.Value{reflect.ValueOf(e), errValue}
+		}
+
+		for i := 0; i < numArgs; i++ {
+			err := k.SetArg(i, in[i+2].Interface())
+			if err != nil {
+				return result(nil, err)
+			}
+		}
+
+		cq := in[0].Interface().(*CommandQueue)
+		global := in[1].Interface().([]int)
+		e := &Event{}
+		err := cq.EnqueueNDRangeKernel(k, nil, global, nil, nil, e)
+		if err != nil {
+			return result(nil, err)
+		}
+		return result(e, nil)
+	}))
+
+	return nil
+}
+
+// Checks that a Go type can be passed for a declared kernel argument.
+func checkKernelArg(ka *KernelArg, t reflect.Type) error {
+
+	switch {
+	case ka.IsImage():
+		if t != imagePtrType {
+			return fmt.Errorf("expected *Image, got %s", t)
+		}
+		return nil
+
+	case ka.IsSampler():
+		if t != samplerPtrType {
+			return fmt.Errorf("expected *Sampler, got %s", t)
+		}
+		return nil
+
+	case ka.Pointer && ka.AddressSpace == LocalSpace:
+		if t != localSpaceType {
+			return fmt.Errorf("expected LocalSpaceArg, got %s", t)
+		}
+		return nil
+
+	case ka.Pointer:
+		if t != bufferPtrType {
+			return fmt.Errorf("expected *Buffer, got %s", t)
+		}
+		return nil
+	}
+
+	if t == bufferPtrType || t == imagePtrType || t == samplerPtrType || t == localSpaceType {
+		return fmt.Errorf("expected a %s value, got %s", ka.Type, t)
+	}
+
+	// Only check the types that have a known Go equivalent.
+	scalar, width := signature.VectorType(ka.Type)
+	if width == 0 {
+		return nil
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if width > 1 {
+		// A 3 component vector has the size and alignment of a 4 component
+		// one, so a [3] array would be too small.
+		if width == 3 {
+			width = 4
+		}
+		if t.Kind() != reflect.Array || t.Len() != width {
+			return fmt.Errorf("expected [%d] array for %s, got %s", width, ka.Type, t)
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != signature.ScalarTypes[scalar].Kind() {
+		return fmt.Errorf("expected %s for %s, got %s", signature.ScalarTypes[scalar], ka.Type, t)
+	}
+
+	return nil
+}
+
+// The kernel's signature parsed from the program source, or nil if it is not
+// available.
+func (k *Kernel) signature() *KernelSignature {
+
+	if k.Program == nil || k.Program.sources == nil {
+		return nil
+	}
+
+	signatures, err := ParseKernelSignatures(bytes.Join(k.Program.sources, []byte("\n")))
+	if err != nil {
+		return nil
+	}
+
+	for i := range signatures {
+		if signatures[i].Name == k.FunctionName {
+			return &signatures[i]
+		}
+	}
+	return nil
+}
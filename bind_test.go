@@ -0,0 +1,78 @@
+package cl11
+
+import (
+	"reflect"
+	"testing"
+)
+
+var bindSource = `
+__kernel void saxpy(float a, __global const float* x, __global float *y)
+{
+	int i = get_global_id(0);
+	y[i] = a * x[i] + y[i];
+}
+`
+
+func TestBindKernelChecks(t *testing.T) {
+	k := &Kernel{
+		Arguments:    3,
+		FunctionName: "saxpy",
+		Program:      &Program{sources: [][]byte{[]byte(bindSource)}},
+	}
+
+	var saxpy func(q *CommandQueue, global []int, a float32, x, y *Buffer) (*Event, error)
+	err := BindKernel(k, &saxpy)
+	if err != nil {
+		t.Error(err)
+	}
+	if saxpy == nil {
+		t.Error("function not bound")
+	}
+
+	var wrongType func(q *CommandQueue, global []int, a float64, x, y *Buffer) (*Event, error)
+	if BindKernel(k, &wrongType) == nil {
+		t.Error("expected error for float64 scalar")
+	}
+
+	var wrongCount func(q *CommandQueue, global []int, a float32, x *Buffer) (*Event, error)
+	if BindKernel(k, &wrongCount) == nil {
+		t.Error("expected error for argument count")
+	}
+
+	var wrongPrefix func(global []int, a float32, x, y *Buffer) (*Event, error)
+	if BindKernel(k, &wrongPrefix) == nil {
+		t.Error("expected error for missing command queue")
+	}
+
+	var wrongResults func(q *CommandQueue, global []int, a float32, x, y *Buffer) error
+	if BindKernel(k, &wrongResults) == nil {
+		t.Error("expected error for results")
+	}
+
+	if BindKernel(k, saxpy) == nil {
+		t.Error("expected error for non pointer")
+	}
+}
+
+func TestCheckKernelArgVectors(t *testing.T) {
+	for _, test := range []struct {
+		typ   string
+		value interface{}
+		ok    bool
+	}{
+		{"float2", [2]float32{}, true},
+		{"float4", [4]float32{}, true},
+		{"float4", [4]float64{}, false},
+		{"int8", [8]int32{}, true},
+		{"float3", [4]float32{}, true},
+		{"float3", [3]float32{}, false},
+		{"sampler_t", &Sampler{}, true},
+		{"sampler_t", int32(0), false},
+		{"int", &Sampler{}, false},
+	} {
+		err := checkKernelArg(&KernelArg{Type: test.typ}, reflect.TypeOf(test.value))
+		if (err == nil) != test.ok {
+			t.Errorf("%s with %T: got %v", test.typ, test.value, err)
+		}
+	}
+}
@@ -127,6 +127,20 @@ func (b *Buffer) ReferenceCount() (int, error) {
 func (cq *CommandQueue) EnqueueCopyBuffer(src, dst *Buffer, srcOffset, dstOffset, size int64, waitList []*Event,
 	e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandCopyBuffer, Buffers: []*Buffer{src, dst},
+			Offsets: []int64{srcOffset, dstOffset}, Size: size, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		src, dst = info.Buffers[0], info.Buffers[1]
+		srcOffset, dstOffset, size = info.Offsets[0], info.Offsets[1], info.Size
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -139,7 +153,8 @@ func (cq *CommandQueue) EnqueueCopyBuffer(src, dst *Buffer, srcOffset, dstOffset
 	err := clw.EnqueueCopyBuffer(cq.id, src.id, dst.id, clw.Size(srcOffset), clw.Size(dstOffset), clw.Size(size),
 		events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", size)
+	return cq.interceptAfter(info, err)
 }
 
 // Enqueues a command to copy a rectangular region from the buffer object to
@@ -148,6 +163,19 @@ func (cq *CommandQueue) EnqueueCopyBuffer(src, dst *Buffer, srcOffset, dstOffset
 // See Rect definition for how source and destination are defined.
 func (cq *CommandQueue) EnqueueCopyBufferRect(src, dst *Buffer, r *Rect, waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandCopyBufferRectangle, Buffers: []*Buffer{src, dst}, Rect: r,
+			WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		src, dst, r = info.Buffers[0], info.Buffers[1], info.Rect
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -160,7 +188,8 @@ func (cq *CommandQueue) EnqueueCopyBufferRect(src, dst *Buffer, r *Rect, waitLis
 	err := clw.EnqueueCopyBufferRect(cq.id, src.id, dst.id, r.Src.origin(), r.Dst.origin(), r.region(),
 		r.Src.rowPitch(), r.Src.slicePitch(), r.Dst.rowPitch(), r.Dst.slicePitch(), events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", int64(r.size()))
+	return cq.interceptAfter(info, err)
 }
 
 // Enqueues a command to map a region of the buffer object given by buffer into
@@ -168,6 +197,20 @@ func (cq *CommandQueue) EnqueueCopyBufferRect(src, dst *Buffer, r *Rect, waitLis
 func (cq *CommandQueue) EnqueueMapBuffer(b *Buffer, bc BlockingCall, flags MapFlags, offset, size int64,
 	waitList []*Event, e *Event) (*MappedBuffer, error) {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandMapBuffer, Buffers: []*Buffer{b}, Offsets: []int64{offset}, Size: size,
+			WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return nil, cq.interceptAfter(info, err)
+		}
+		b, offset, size = info.Buffers[0], info.Offsets[0], info.Size
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+	e, own := cq.mapEvent(info, e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -180,6 +223,8 @@ func (cq *CommandQueue) EnqueueMapBuffer(b *Buffer, bc BlockingCall, flags MapFl
 	pointer, err := clw.EnqueueMapBuffer(cq.id, b.id, clw.Bool(bc), clw.MapFlags(flags), clw.Size(offset),
 		clw.Size(size), events, event)
 	cq.releaseEvents(events)
+	cq.profileCommand(e, internal, err, "", size)
+	err = cq.interceptMapAfter(info, err, b.id, pointer, e, own)
 	if err != nil {
 		return nil, err
 	}
@@ -195,6 +240,18 @@ func (cq *CommandQueue) EnqueueMapBuffer(b *Buffer, bc BlockingCall, flags MapFl
 // Enqueues a command to unmap a previously mapped buffer object.
 func (cq *CommandQueue) EnqueueUnmapBuffer(mb *MappedBuffer, waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandUnmapMemoryObject, Buffers: []*Buffer{mb.Buffer},
+			WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -206,5 +263,6 @@ func (cq *CommandQueue) EnqueueUnmapBuffer(mb *MappedBuffer, waitList []*Event,
 	events := cq.createEvents(waitList)
 	err := clw.EnqueueUnmapMemObject(cq.id, mb.Buffer.id, mb.pointer, events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", mb.size)
+	return cq.interceptAfter(info, err)
 }
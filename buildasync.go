@@ -0,0 +1,90 @@
+package cl11
+
+import (
+	"context"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// The outcome of an asynchronous build.
+type BuildResult struct {
+
+	// The program that was built.
+	Program *Program
+
+	// The build status and log for each device. Nil if the wait was cancelled.
+	Logs []DeviceBuildLog
+
+	// Nil on success, a *ProgramBuildError if the build failed, the context's
+	// error if the wait was cancelled or any other error.
+	Err error
+}
+
+// Builds the program for the devices without blocking.
+//
+// The result is delivered on the returned channel, which is then closed. If ctx
+// is done before the build completes, the result's Err is ctx.Err(). The build
+// itself can not be cancelled and continues in the background, the program must
+// not be released until it has completed. Building several programs this way
+// lets the implementation compile them in parallel.
+func (p *Program) BuildAsync(ctx context.Context, d []*Device, options string) <-chan BuildResult {
+
+	results := make(chan BuildResult, 1)
+	built := make(chan BuildResult, 1)
+
+	p.Options = options
+
+	// The build blocks a goroutine of its own rather than registering a build
+	// callback, which the implementation would keep registered once called.
+	go func() {
+		built <- p.buildResult(d, options)
+	}()
+
+	go func() {
+		defer close(results)
+		select {
+		case result := <-built:
+			results <- result
+		case <-ctx.Done():
+			results <- BuildResult{Program: p, Err: ctx.Err()}
+		}
+	}()
+
+	return results
+}
+
+// Builds the program, blocking until the build completes, and returns the
+// result with the build logs.
+func (p *Program) buildResult(d []*Device, options string) BuildResult {
+
+	devices := make([]clw.DeviceID, len(d))
+	for i := range d {
+		devices[i] = d[i].id
+	}
+
+	err := clw.BuildProgram(p.id, devices, options, nil, nil)
+	if err != nil && err != BuildProgramFailure {
+		return BuildResult{Program: p, Err: err}
+	}
+
+	logs, logErr := p.buildLogs(d)
+	if logErr != nil {
+		if err == BuildProgramFailure || p.buildFailed(d) {
+			logErr = &ProgramBuildError{Program: p, LogErr: logErr}
+		}
+		return BuildResult{Program: p, Err: logErr}
+	}
+
+	result := BuildResult{Program: p, Logs: logs}
+	if err == BuildProgramFailure {
+		result.Err = p.newBuildError(logs)
+		return result
+	}
+	for _, log := range logs {
+		if log.Status != BuildSuccess {
+			result.Err = p.newBuildError(logs)
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,225 @@
+package cl11
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The error returned by Program.Build when the build fails. It contains the
+// status and log of each device and the diagnostics parsed from the logs.
+//
+// ProgramBuildError unwraps to BuildProgramFailure, so errors.Is(err,
+// BuildProgramFailure) continues to work.
+type ProgramBuildError struct {
+
+	// The program that failed to build.
+	Program *Program
+
+	// The build status and log for each device the program was built for.
+	Logs []DeviceBuildLog
+
+	// The diagnostics parsed from all the logs, in log order.
+	Diagnostics []Diagnostic
+
+	// The error that prevented the logs from being retrieved, if any, in which
+	// case Logs and Diagnostics are empty.
+	LogErr error
+}
+
+// The result of a build for a single device.
+type DeviceBuildLog struct {
+	Device *Device
+	Status BuildStatus
+	Log    string
+}
+
+// A single compiler message.
+type Diagnostic struct {
+
+	// The device whose build log the diagnostic is from.
+	Device *Device
+
+	// The file name as reported by the compiler. Compilers use a placeholder,
+	// such as "<kernel>", unless the source contains #line directives.
+	File string
+
+	// When the program was created from multiple sources, the index of the
+	// source the diagnostic refers to. Line is relative to that source.
+	Source int
+
+	// The line and column, starting at 1. Column is 0 if not reported.
+	Line   int
+	Column int
+
+	Severity Severity
+	Message  string
+}
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return ""
+}
+
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+func (be *ProgramBuildError) Error() string {
+	if be.LogErr != nil {
+		return BuildProgramFailure.Error() + " (build log unavailable: " + be.LogErr.Error() + ")"
+	}
+	for _, d := range be.Diagnostics {
+		if d.Severity == SeverityError {
+			return BuildProgramFailure.Error() + ": " + d.String()
+		}
+	}
+	return BuildProgramFailure.Error()
+}
+
+// Returns BuildProgramFailure.
+func (be *ProgramBuildError) Unwrap() error {
+	return BuildProgramFailure
+}
+
+// Collects the logs of a failed build. Failing to retrieve them does not hide
+// the build failure.
+func (p *Program) buildError(d []*Device) error {
+
+	logs, err := p.buildLogs(d)
+	if err != nil {
+		return &ProgramBuildError{Program: p, LogErr: err}
+	}
+
+	return p.newBuildError(logs)
+}
+
+func (p *Program) newBuildError(logs []DeviceBuildLog) *ProgramBuildError {
+	be := &ProgramBuildError{Program: p, Logs: logs}
+	for _, log := range logs {
+		be.Diagnostics = append(be.Diagnostics, parseBuildLog(log.Device, log.Log, p.sources)...)
+	}
+	return be
+}
+
+// True if the build failed for any of the devices, false if it did not or the
+// status can not be retrieved.
+func (p *Program) buildFailed(d []*Device) bool {
+	for i := range d {
+		status, err := p.BuildStatus(d[i])
+		if err == nil && status == BuildError {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the build status and log for each device.
+func (p *Program) buildLogs(d []*Device) ([]DeviceBuildLog, error) {
+	logs := make([]DeviceBuildLog, len(d))
+	for i := range d {
+		status, err := p.BuildStatus(d[i])
+		if err != nil {
+			return nil, err
+		}
+		log, err := p.BuildLog(d[i])
+		if err != nil {
+			return nil, err
+		}
+		logs[i] = DeviceBuildLog{Device: d[i], Status: status, Log: log}
+	}
+	return logs, nil
+}
+
+var (
+	// Clang based compilers (Intel, NVIDIA, recent AMD and pocl), e.g.
+	// "<kernel>:3:5: error: use of undeclared identifier 'x'".
+	clangDiagnostic = regexp.MustCompile(
+		`^(.*?):(\d+):(?:(\d+):)? (fatal error|error|warning|note|remark): (.*)$`)
+
+	// EDG based compilers (older AMD), e.g.
+	// `"/tmp/OCL1234.cl", line 3: error: identifier "x" is undefined`.
+	edgDiagnostic = regexp.MustCompile(
+		`^"(.*)", line (\d+): (catastrophic error|error|warning|remark)(?: #[\w-]+)?: (.*)$`)
+)
+
+// Parses the diagnostics out of a build log. Lines that are not diagnostics,
+// e.g. source excerpts and carets, are ignored.
+func parseBuildLog(d *Device, log string, sources [][]byte) []Diagnostic {
+
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		var diagnostic Diagnostic
+		if m := clangDiagnostic.FindStringSubmatch(line); m != nil {
+			diagnostic.File = m[1]
+			diagnostic.Line, _ = strconv.Atoi(m[2])
+			diagnostic.Column, _ = strconv.Atoi(m[3])
+			diagnostic.Severity = toSeverity(m[4])
+			diagnostic.Message = m[5]
+		} else if m := edgDiagnostic.FindStringSubmatch(line); m != nil {
+			diagnostic.File = m[1]
+			diagnostic.Line, _ = strconv.Atoi(m[2])
+			diagnostic.Severity = toSeverity(m[3])
+			diagnostic.Message = m[4]
+		} else {
+			continue
+		}
+
+		diagnostic.Device = d
+		diagnostic.Source, diagnostic.Line = sourceLine(sources, diagnostic.Line)
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	return diagnostics
+}
+
+func toSeverity(s string) Severity {
+	switch s {
+	case "warning":
+		return SeverityWarning
+	case "note", "remark":
+		return SeverityNote
+	}
+	return SeverityError
+}
+
+// Maps a line in the concatenation of sources, which is what the compiler
+// sees, to the index of a source and the line within it.
+func sourceLine(sources [][]byte, line int) (int, int) {
+	if len(sources) < 2 {
+		return 0, line
+	}
+
+	start := 1
+	for i, source := range sources {
+		lines := bytes.Count(source, []byte("\n"))
+		if i == len(sources)-1 || line <= start+lines-1 ||
+			(line == start+lines && !bytes.HasSuffix(source, []byte("\n"))) {
+			return i, line - start + 1
+		}
+		start += lines
+	}
+	panic("unreachable")
+}
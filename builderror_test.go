@@ -0,0 +1,92 @@
+package cl11
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBuildLog(t *testing.T) {
+	d := &Device{Name: "test"}
+	tests := []struct {
+		vendor string
+		log    string
+		want   []Diagnostic
+	}{
+		{
+			"Intel",
+			"fcl build 1 failed\n<kernel>:3:5: error: use of undeclared identifier 'x'\n    x = 1;\n    ^\n",
+			[]Diagnostic{{Device: d, File: "<kernel>", Line: 3, Column: 5, Severity: SeverityError,
+				Message: "use of undeclared identifier 'x'"}},
+		},
+		{
+			"AMD",
+			"\"/tmp/OCL1234.cl\", line 7: error: identifier \"x\" is undefined\n\t  x = 1;\n\t  ^\n\n" +
+				"\"/tmp/OCL1234.cl\", line 9: warning #550-D: variable \"y\" was set but never used\n",
+			[]Diagnostic{
+				{Device: d, File: "/tmp/OCL1234.cl", Line: 7, Severity: SeverityError,
+					Message: "identifier \"x\" is undefined"},
+				{Device: d, File: "/tmp/OCL1234.cl", Line: 9, Severity: SeverityWarning,
+					Message: "variable \"y\" was set but never used"},
+			},
+		},
+		{
+			"NVIDIA",
+			"<kernel>:2:10: warning: unused variable 'z'\n<kernel>:4:1: error: expected ';' after expression\n",
+			[]Diagnostic{
+				{Device: d, File: "<kernel>", Line: 2, Column: 10, Severity: SeverityWarning,
+					Message: "unused variable 'z'"},
+				{Device: d, File: "<kernel>", Line: 4, Column: 1, Severity: SeverityError,
+					Message: "expected ';' after expression"},
+			},
+		},
+		{
+			"pocl",
+			"<stdin>:12:3: note: previous definition is here\n",
+			[]Diagnostic{{Device: d, File: "<stdin>", Line: 12, Column: 3, Severity: SeverityNote,
+				Message: "previous definition is here"}},
+		},
+	}
+	for _, test := range tests {
+		got := parseBuildLog(d, test.log, nil)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.vendor, got, test.want)
+		}
+	}
+}
+
+func TestSourceLine(t *testing.T) {
+	sources := [][]byte{[]byte("a\nb\n"), []byte("c\nd"), []byte("e\nf\n")}
+	tests := []struct{ line, source, sourceLine int }{
+		{1, 0, 1},
+		{2, 0, 2},
+		{3, 1, 1},
+		{4, 1, 2}, // "de" since the second source has no trailing newline.
+		{5, 2, 2},
+	}
+	for _, test := range tests {
+		source, line := sourceLine(sources, test.line)
+		if source != test.source || line != test.sourceLine {
+			t.Errorf("line %d: got source %d line %d, want source %d line %d", test.line, source, line,
+				test.source, test.sourceLine)
+		}
+	}
+}
+
+func TestProgramBuildErrorIs(t *testing.T) {
+	var err error = &ProgramBuildError{}
+	if !errors.Is(err, BuildProgramFailure) {
+		t.Error("ProgramBuildError is not BuildProgramFailure")
+	}
+}
+
+func TestProgramBuildErrorLogErr(t *testing.T) {
+	var err error = &ProgramBuildError{LogErr: InvalidDevice}
+	if !errors.Is(err, BuildProgramFailure) {
+		t.Error("ProgramBuildError without logs is not BuildProgramFailure")
+	}
+	if !strings.Contains(err.Error(), InvalidDevice.Error()) {
+		t.Error("log error missing from", err)
+	}
+}
@@ -0,0 +1,330 @@
+package cl11
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Typed program build options, see Program.Build.
+//
+// String renders the options in a canonical order so equal options always give
+// the same string, making it suitable as a cache key. ParseBuildOptions does
+// the reverse. The setter methods return the options to allow chaining.
+type BuildOptions struct {
+
+	// Preprocessor macros, -D name or -D name=value. An empty value defines
+	// the macro without a value.
+	Defines map[string]string
+
+	// Directories searched for header files, -I dir, in search order.
+	IncludeDirs []string
+
+	// The OpenCL C version to compile for, -cl-std=CLx.y. The zero value
+	// leaves it to the compiler.
+	Std Version
+
+	SinglePrecisionConstant bool // -cl-single-precision-constant
+	DenormsAreZero          bool // -cl-denorms-are-zero
+	OptDisable              bool // -cl-opt-disable
+	MadEnable               bool // -cl-mad-enable
+	NoSignedZeros           bool // -cl-no-signed-zeros
+	UnsafeMathOptimizations bool // -cl-unsafe-math-optimizations
+	FiniteMathOnly          bool // -cl-finite-math-only
+	FastRelaxedMath         bool // -cl-fast-relaxed-math
+	KernelArgInfo           bool // -cl-kernel-arg-info, OpenCL C 1.2.
+	DisableWarnings         bool // -w
+	Werror                  bool // -Werror
+
+	// Any other, e.g. vendor specific, options in order.
+	Other []string
+}
+
+// The boolean flags in their canonical order, with the OpenCL C version that
+// introduced them.
+var buildFlags = []struct {
+	flag    string
+	field   func(bo *BuildOptions) *bool
+	version Version
+}{
+	{"-cl-single-precision-constant", func(bo *BuildOptions) *bool { return &bo.SinglePrecisionConstant },
+		Version{Major: 1}},
+	{"-cl-denorms-are-zero", func(bo *BuildOptions) *bool { return &bo.DenormsAreZero }, Version{Major: 1}},
+	{"-cl-opt-disable", func(bo *BuildOptions) *bool { return &bo.OptDisable }, Version{Major: 1}},
+	{"-cl-mad-enable", func(bo *BuildOptions) *bool { return &bo.MadEnable }, Version{Major: 1}},
+	{"-cl-no-signed-zeros", func(bo *BuildOptions) *bool { return &bo.NoSignedZeros }, Version{Major: 1}},
+	{"-cl-unsafe-math-optimizations", func(bo *BuildOptions) *bool { return &bo.UnsafeMathOptimizations },
+		Version{Major: 1}},
+	{"-cl-finite-math-only", func(bo *BuildOptions) *bool { return &bo.FiniteMathOnly }, Version{Major: 1}},
+	{"-cl-fast-relaxed-math", func(bo *BuildOptions) *bool { return &bo.FastRelaxedMath }, Version{Major: 1}},
+	{"-cl-kernel-arg-info", func(bo *BuildOptions) *bool { return &bo.KernelArgInfo },
+		Version{Major: 1, Minor: 2}},
+	{"-w", func(bo *BuildOptions) *bool { return &bo.DisableWarnings }, Version{Major: 1}},
+	{"-Werror", func(bo *BuildOptions) *bool { return &bo.Werror }, Version{Major: 1}},
+}
+
+// Defines a preprocessor macro. An empty value defines the macro without a
+// value.
+func (bo *BuildOptions) Define(name, value string) *BuildOptions {
+	if bo.Defines == nil {
+		bo.Defines = make(map[string]string)
+	}
+	bo.Defines[name] = value
+	return bo
+}
+
+// Adds a directory to the header search path.
+func (bo *BuildOptions) Include(dir string) *BuildOptions {
+	bo.IncludeDirs = append(bo.IncludeDirs, dir)
+	return bo
+}
+
+// Sets the OpenCL C version to compile for.
+func (bo *BuildOptions) SetStd(major, minor int) *BuildOptions {
+	bo.Std = Version{Major: major, Minor: minor}
+	return bo
+}
+
+// Sets -cl-fast-relaxed-math.
+func (bo *BuildOptions) SetFastRelaxedMath() *BuildOptions {
+	bo.FastRelaxedMath = true
+	return bo
+}
+
+// Sets -cl-mad-enable.
+func (bo *BuildOptions) SetMadEnable() *BuildOptions {
+	bo.MadEnable = true
+	return bo
+}
+
+// Sets -cl-opt-disable.
+func (bo *BuildOptions) SetOptDisable() *BuildOptions {
+	bo.OptDisable = true
+	return bo
+}
+
+// Sets -Werror.
+func (bo *BuildOptions) SetWerror() *BuildOptions {
+	bo.Werror = true
+	return bo
+}
+
+// Adds an option that has no typed equivalent.
+func (bo *BuildOptions) Add(option string) *BuildOptions {
+	bo.Other = append(bo.Other, option)
+	return bo
+}
+
+// Checks the options can be used to build for the device: macro names are
+// identifiers and the requested OpenCL C version and flags are supported by the
+// device's compiler.
+func (bo *BuildOptions) Validate(d *Device) error {
+
+	for name := range bo.Defines {
+		if !isIdentifier(name) {
+			return fmt.Errorf("cl: BuildOptions: invalid macro name %q", name)
+		}
+	}
+
+	if bo.Std != (Version{}) {
+		if bo.Std.Major < 1 || (bo.Std.Major == 1 && bo.Std.Minor < 1) {
+			return fmt.Errorf("cl: BuildOptions: invalid OpenCL C version %s", bo.Std)
+		}
+		if versionLess(d.OpenCLCVersion, bo.Std) {
+			return fmt.Errorf("cl: BuildOptions: %s supports up to OpenCL C %d.%d, %d.%d requested", d.Name,
+				d.OpenCLCVersion.Major, d.OpenCLCVersion.Minor, bo.Std.Major, bo.Std.Minor)
+		}
+	}
+
+	for _, f := range buildFlags {
+		if *f.field(bo) && versionLess(d.OpenCLCVersion, f.version) {
+			return fmt.Errorf("cl: BuildOptions: %s requires OpenCL C %d.%d, %s supports %d.%d", f.flag,
+				f.version.Major, f.version.Minor, d.Name, d.OpenCLCVersion.Major, d.OpenCLCVersion.Minor)
+		}
+	}
+
+	return nil
+}
+
+// Renders the options in canonical order: defines sorted by name, include
+// directories, the OpenCL C version, flags and then any other options.
+func (bo *BuildOptions) String() string {
+
+	var options []string
+
+	names := make([]string, 0, len(bo.Defines))
+	for name := range bo.Defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value := bo.Defines[name]; value != "" {
+			options = append(options, "-D", quoteBuildOption(name+"="+value))
+		} else {
+			options = append(options, "-D", quoteBuildOption(name))
+		}
+	}
+
+	for _, dir := range bo.IncludeDirs {
+		options = append(options, "-I", quoteBuildOption(dir))
+	}
+
+	if bo.Std != (Version{}) {
+		options = append(options, fmt.Sprintf("-cl-std=CL%d.%d", bo.Std.Major, bo.Std.Minor))
+	}
+
+	for _, f := range buildFlags {
+		if *f.field(bo) {
+			options = append(options, f.flag)
+		}
+	}
+
+	for _, option := range bo.Other {
+		options = append(options, quoteBuildOption(option))
+	}
+
+	return strings.Join(options, " ")
+}
+
+// Parses a build options string, such as Program.Options.
+func ParseBuildOptions(options string) (*BuildOptions, error) {
+
+	args, err := splitBuildOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	bo := &BuildOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// The value of -D and -I may be attached or the next argument.
+		if arg == "-D" || arg == "-I" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("cl: ParseBuildOptions: missing value for %s", arg)
+			}
+			arg += args[i+1]
+			i++
+		}
+
+		switch {
+		case strings.HasPrefix(arg, "-D"):
+			definition := arg[2:]
+			if j := strings.Index(definition, "="); j >= 0 {
+				bo.Define(definition[:j], definition[j+1:])
+			} else {
+				bo.Define(definition, "")
+			}
+			continue
+
+		case strings.HasPrefix(arg, "-I"):
+			bo.Include(arg[2:])
+			continue
+
+		case strings.HasPrefix(arg, "-cl-std=CL"):
+			_, err = fmt.Sscanf(arg, "-cl-std=CL%d.%d", &bo.Std.Major, &bo.Std.Minor)
+			if err != nil {
+				return nil, fmt.Errorf("cl: ParseBuildOptions: invalid %s", arg)
+			}
+			continue
+		}
+
+		known := false
+		for _, f := range buildFlags {
+			if arg == f.flag {
+				*f.field(bo) = true
+				known = true
+				break
+			}
+		}
+		if !known {
+			bo.Other = append(bo.Other, arg)
+		}
+	}
+
+	return bo, nil
+}
+
+// Quotes an option if it contains characters the compiler would otherwise
+// split or interpret.
+func quoteBuildOption(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Splits an options string on white space, honoring double and single quotes
+// and backslash escapes.
+func splitBuildOptions(s string) ([]string, error) {
+
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("cl: ParseBuildOptions: unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+func versionLess(a, b Version) bool {
+	return a.Major < b.Major || (a.Major == b.Major && a.Minor < b.Minor)
+}
@@ -0,0 +1,71 @@
+package cl11
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildOptionsString(t *testing.T) {
+	bo := (&BuildOptions{}).
+		Define("TILE", "16").
+		Define("NAME", `a "b"`).
+		Define("DEBUG", "").
+		Include("/usr/include/my kernels").
+		SetStd(1, 1).
+		SetWerror().
+		SetFastRelaxedMath().
+		SetMadEnable()
+
+	want := `-D DEBUG -D "NAME=a \"b\"" -D TILE=16 -I "/usr/include/my kernels" -cl-std=CL1.1 -cl-mad-enable ` +
+		`-cl-fast-relaxed-math -Werror`
+	if got := bo.String(); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	parsed, err := ParseBuildOptions(bo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, bo) {
+		t.Errorf("round trip: got %+v, want %+v", parsed, bo)
+	}
+}
+
+func TestParseBuildOptions(t *testing.T) {
+	bo, err := ParseBuildOptions(`-DA=1 -D B -Iinc -cl-denorms-are-zero -w -nv-verbose 'x y'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BuildOptions{
+		Defines:         map[string]string{"A": "1", "B": ""},
+		IncludeDirs:     []string{"inc"},
+		DenormsAreZero:  true,
+		DisableWarnings: true,
+		Other:           []string{"-nv-verbose", "x y"},
+	}
+	if !reflect.DeepEqual(bo, want) {
+		t.Errorf("got %+v, want %+v", bo, want)
+	}
+
+	_, err = ParseBuildOptions(`-D "A=1`)
+	if err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestBuildOptionsValidate(t *testing.T) {
+	d := &Device{Name: "test", OpenCLCVersion: Version{Major: 1, Minor: 1}}
+
+	if err := (&BuildOptions{}).SetStd(1, 1).SetMadEnable().Validate(d); err != nil {
+		t.Error(err)
+	}
+	if (&BuildOptions{}).SetStd(1, 2).Validate(d) == nil {
+		t.Error("expected error for unsupported OpenCL C version")
+	}
+	if (&BuildOptions{KernelArgInfo: true}).Validate(d) == nil {
+		t.Error("expected error for -cl-kernel-arg-info on OpenCL C 1.1")
+	}
+	if (&BuildOptions{}).Define("1BAD", "").Validate(d) == nil {
+		t.Error("expected error for invalid macro name")
+	}
+}
@@ -0,0 +1,156 @@
+package cl11
+
+import (
+	"math"
+	"time"
+)
+
+// A ClockCalibration converts device profiling timestamps of a command queue
+// to host time, so device activity can be aligned with host side traces.
+//
+// Device timestamps are nanoseconds from an arbitrary epoch and the device
+// clock may run at a slightly different rate from the host's, so the
+// calibration models host time as a linear function of device time.
+type ClockCalibration struct {
+
+	// The command queue the calibration was made with. It applies to all
+	// queues of the same device.
+	CommandQueue *CommandQueue
+
+	// The rate difference between the clocks: host nanoseconds elapsed per
+	// device nanosecond minus one. Zero if calibrated with a single sample.
+	Drift float64
+
+	// An estimate of the conversion error, the largest uncertainty of a sample
+	// plus the largest deviation of a sample from the model.
+	Uncertainty time.Duration
+
+	// Host time = hostBase + offset + (1 + Drift) * (device time - deviceBase).
+	hostBase   time.Time
+	deviceBase int64
+	offset     float64
+}
+
+// A host and device time pair.
+type clockSample struct {
+	host        int64 // Nanoseconds since the host base.
+	device      int64 // Nanoseconds since the device base.
+	uncertainty int64 // Half the duration of the host measurement.
+}
+
+// Estimates the relation between the device clock of the command queue, which
+// must have been created with QueueProfilingEnable, and the host's monotonic
+// clock.
+//
+// Each sample enqueues a marker and pairs the time the host spent in
+// EnqueueMarker with the marker's queued timestamp. Samples are taken interval
+// apart; the longer the samples span the better the drift estimate. At least
+// one sample is taken.
+func (cq *CommandQueue) CalibrateClock(samples int, interval time.Duration) (*ClockCalibration, error) {
+
+	if cq.Properties&QueueProfilingEnable == 0 {
+		return nil, ProfilingInfoNotAvailable
+	}
+	if samples < 1 {
+		samples = 1
+	}
+
+	var hostBase time.Time
+	var deviceBase int64
+	measured := make([]clockSample, samples)
+	for i := range measured {
+
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		var e Event
+		before := time.Now()
+		err := cq.EnqueueMarker(&e)
+		after := time.Now()
+		if err != nil {
+			return nil, err
+		}
+
+		err = e.Wait()
+		if err == nil {
+			err = e.GetProfilingInfo()
+		}
+		if releaseErr := e.Release(); err == nil {
+			err = releaseErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			hostBase, deviceBase = before, e.Queued
+		}
+		measured[i] = clockSample{
+			host:        int64(before.Sub(hostBase) + after.Sub(before)/2),
+			device:      e.Queued - deviceBase,
+			uncertainty: int64(after.Sub(before) / 2),
+		}
+	}
+
+	offset, drift := fitClock(measured)
+
+	var uncertainty float64
+	for _, s := range measured {
+		deviation := math.Abs(float64(s.host) - (offset + (1+drift)*float64(s.device)))
+		uncertainty = math.Max(uncertainty, deviation+float64(s.uncertainty))
+	}
+
+	return &ClockCalibration{
+		CommandQueue: cq,
+		Drift:        drift,
+		Uncertainty:  time.Duration(uncertainty),
+		hostBase:     hostBase,
+		deviceBase:   deviceBase,
+		offset:       offset,
+	}, nil
+}
+
+// Fits host = offset + (1 + drift) * device by least squares, weighting each
+// sample by the inverse square of its uncertainty.
+func fitClock(samples []clockSample) (offset, drift float64) {
+
+	var sumW, sumX, sumY float64
+	weights := make([]float64, len(samples))
+	for i, s := range samples {
+		u := math.Max(float64(s.uncertainty), 1)
+		weights[i] = 1 / (u * u)
+		sumW += weights[i]
+		sumX += weights[i] * float64(s.device)
+		sumY += weights[i] * float64(s.host)
+	}
+	meanX, meanY := sumX/sumW, sumY/sumW
+
+	var sxx, sxy float64
+	for i, s := range samples {
+		dx := float64(s.device) - meanX
+		sxx += weights[i] * dx * dx
+		sxy += weights[i] * dx * (float64(s.host) - meanY)
+	}
+
+	slope := 1.0
+	if sxx > 0 {
+		slope = sxy / sxx
+	}
+	return meanY - slope*meanX, slope - 1
+}
+
+// Converts a device timestamp in nanoseconds, such as Event.Start, to host
+// time. The result has a monotonic clock reading so it can be compared with
+// time.Now.
+func (cc *ClockCalibration) Time(device int64) time.Time {
+	elapsed := cc.offset + (1+cc.Drift)*float64(device-cc.deviceBase)
+	return cc.hostBase.Add(time.Duration(math.Round(elapsed)))
+}
+
+// Returns the host times at which the event's command started and ended
+// executing. The event's profiling information must have been retrieved with
+// GetProfilingInfo.
+func (cc *ClockCalibration) EventTimes(e *Event) (start, end time.Time) {
+	return cc.Time(e.Start), cc.Time(e.End)
+}
@@ -0,0 +1,92 @@
+package cl11
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFitClock(t *testing.T) {
+	// The host clock runs 100 ppm faster and is 5 µs ahead.
+	var samples []clockSample
+	for i := int64(0); i < 10; i++ {
+		device := i * int64(time.Second)
+		samples = append(samples, clockSample{host: 5000 + device + device/10000, device: device, uncertainty: 1000})
+	}
+
+	offset, drift := fitClock(samples)
+	if math.Abs(offset-5000) > 1 || math.Abs(drift-1e-4) > 1e-9 {
+		t.Errorf("got offset %v and drift %v, want 5000 and 1e-4", offset, drift)
+	}
+
+	offset, drift = fitClock(samples[:1])
+	if offset != 5000 || drift != 0 {
+		t.Errorf("got offset %v and drift %v from one sample, want 5000 and 0", offset, drift)
+	}
+
+	base := time.Now()
+	cc := &ClockCalibration{hostBase: base, deviceBase: 1000, offset: 5000, Drift: 1e-4}
+	if got, want := cc.Time(1000+int64(time.Second)), base.Add(time.Second+105*time.Microsecond); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCalibrateClock(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, QueueProfilingEnable)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		cc, err := cq.CalibrateClock(5, 10*time.Millisecond)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		t.Log("drift", cc.Drift, "uncertainty", cc.Uncertainty)
+
+		var e Event
+		before := time.Now()
+		err = cq.EnqueueMarker(&e)
+		after := time.Now()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, &e)
+
+		err = e.Wait()
+		if err == nil {
+			err = e.GetProfilingInfo()
+		}
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		slack := cc.Uncertainty + time.Millisecond
+		if queued := cc.Time(e.Queued); queued.Before(before.Add(-slack)) || queued.After(after.Add(slack)) {
+			t.Errorf("marker queued at %v, enqueued between %v and %v", queued, before, after)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
@@ -0,0 +1,294 @@
+// Clgen generates Go bindings for the kernels in OpenCL C source files.
+//
+// The generated file contains the sources as string constants, a constructor
+// that creates and builds the program and creates every kernel, and a typed
+// method per kernel that sets the arguments and enqueues the kernel. It is
+// intended to be used with go generate:
+//
+//	//go:generate clgen -package img -type Kernels -o kernels_cl.go gray.cl blur.cl
+//
+// Kernel arguments map to Go types as follows: global and constant pointers to
+// *cl.Buffer, local pointers to cl.LocalSpaceArg, images to *cl.Image, samplers
+// to *cl.Sampler, OpenCL C scalars to their Go equivalent, vectors to arrays and
+// anything else (e.g. a struct or typedef) to interface{}.
+//
+// Clgen only parses the sources, it does not need cgo or an OpenCL library.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rdwilliamson/cl11/internal/signature"
+)
+
+var (
+	packageName = flag.String("package", "", "package name of the generated file (default: from the directory)")
+	typeName    = flag.String("type", "Kernels", "name of the generated type")
+	output      = flag.String("o", "", "output file name (default: <type>_cl.go in lower case)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: clgen [flags] file.cl...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pkg := *packageName
+	if pkg == "" {
+		pkg = os.Getenv("GOPACKAGE")
+	}
+	if pkg == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			fatal(err)
+		}
+		pkg = filepath.Base(wd)
+	}
+
+	var files []clFile
+	for _, name := range flag.Args() {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			fatal(err)
+		}
+		files = append(files, clFile{name: filepath.Base(name), source: source})
+	}
+
+	result, err := generate(pkg, *typeName, files)
+	if err != nil {
+		fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.ToLower(*typeName) + "_cl.go"
+	}
+	err = ioutil.WriteFile(out, result, 0666)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "clgen:", err)
+	os.Exit(1)
+}
+
+type clFile struct {
+	name   string
+	source []byte
+}
+
+// The generated method's own parameters, kernel argument names are renamed to
+// avoid them.
+var reservedNames = map[string]bool{
+	"k": true, "q": true, "globalSize": true, "localSize": true, "waitList": true, "e": true, "err": true,
+	"cl": true,
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true, "defer": true,
+	"else": true, "fallthrough": true, "for": true, "func": true, "go": true, "goto": true, "if": true,
+	"import": true, "interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
+
+// Generates the formatted Go source for the kernels in files.
+func generate(pkg, typeName string, files []clFile) ([]byte, error) {
+
+	var kernels []signature.KernelSignature
+	seen := make(map[string]string)
+	for _, file := range files {
+		signatures, err := signature.ParseKernelSignatures(file.source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file.name, err)
+		}
+		for _, signature := range signatures {
+			name := exportedName(signature.Name)
+			if name == "Release" || name == "Program" {
+				return nil, fmt.Errorf("%s: kernel %s collides with the generated %s", file.name, signature.Name,
+					name)
+			}
+			// Distinct kernel names such as to_gray and toGray share the same
+			// generated identifiers.
+			if other, ok := seen[name]; ok {
+				return nil, fmt.Errorf("%s: kernel %s collides with %s", file.name, signature.Name, other)
+			}
+			seen[name] = fmt.Sprintf("%s in %s", signature.Name, file.name)
+			kernels = append(kernels, signature)
+		}
+	}
+	if len(kernels) == 0 {
+		return nil, fmt.Errorf("no kernels found")
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by clgen from %s. DO NOT EDIT.\n\n", strings.Join(names, ", "))
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import cl \"github.com/rdwilliamson/cl11\"\n\n")
+
+	sourcesName := lowerFirst(typeName) + "Sources"
+	fmt.Fprintf(&b, "// The OpenCL C sources of %s, one per file.\n", typeName)
+	fmt.Fprintf(&b, "var %s = []string{\n", sourcesName)
+	for _, file := range files {
+		fmt.Fprintf(&b, "\t// %s\n\t%s,\n", file.name, strconv.Quote(string(file.source)))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// %s holds the program and kernels built from %s.\n", typeName, strings.Join(names, ", "))
+	fmt.Fprintf(&b, "type %s struct {\n", typeName)
+	fmt.Fprintf(&b, "\tProgram *cl.Program\n\n")
+	for _, kernel := range kernels {
+		fmt.Fprintf(&b, "\t%s *cl.Kernel\n", kernelField(kernel.Name))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// Creates and builds the program for the devices and creates its kernels.\n")
+	fmt.Fprintf(&b, "func New%s(c *cl.Context, d []*cl.Device, options string) (*%s, error) {\n\n",
+		typeName, typeName)
+	fmt.Fprintf(&b, "\tsources := make([][]byte, len(%s))\n", sourcesName)
+	fmt.Fprintf(&b, "\tfor i := range sources {\n\t\tsources[i] = []byte(%s[i])\n\t}\n\n", sourcesName)
+	fmt.Fprintf(&b, "\tp, err := c.CreateProgramWithSource(sources...)\n")
+	fmt.Fprintf(&b, "\tif err != nil {\n\t\treturn nil, err\n\t}\n\n")
+	fmt.Fprintf(&b, "\tk := &%s{Program: p}\n\n", typeName)
+	fmt.Fprintf(&b, "\terr = p.Build(d, options, nil, nil)\n")
+	fmt.Fprintf(&b, "\tif err != nil {\n\t\tk.Release()\n\t\treturn nil, err\n\t}\n\n")
+	for _, kernel := range kernels {
+		fmt.Fprintf(&b, "\tk.%s, err = p.CreateKernel(%s)\n", kernelField(kernel.Name), strconv.Quote(kernel.Name))
+		fmt.Fprintf(&b, "\tif err != nil {\n\t\tk.Release()\n\t\treturn nil, err\n\t}\n\n")
+	}
+	fmt.Fprintf(&b, "\treturn k, nil\n}\n\n")
+
+	fmt.Fprintf(&b, "// Releases the kernels and the program.\n")
+	fmt.Fprintf(&b, "func (k *%s) Release() error {\n", typeName)
+	fmt.Fprintf(&b, "\tvar err error\n")
+	fmt.Fprintf(&b, "\tfor _, kernel := range []*cl.Kernel{")
+	for i, kernel := range kernels {
+		if i > 0 {
+			fmt.Fprintf(&b, ", ")
+		}
+		fmt.Fprintf(&b, "k.%s", kernelField(kernel.Name))
+	}
+	fmt.Fprintf(&b, "} {\n")
+	fmt.Fprintf(&b, "\t\tif kernel != nil {\n")
+	fmt.Fprintf(&b, "\t\t\tif releaseErr := kernel.Release(); err == nil {\n\t\t\t\terr = releaseErr\n\t\t\t}\n")
+	fmt.Fprintf(&b, "\t\t}\n\t}\n")
+	fmt.Fprintf(&b, "\tif releaseErr := k.Program.Release(); err == nil {\n\t\terr = releaseErr\n\t}\n")
+	fmt.Fprintf(&b, "\treturn err\n}\n")
+
+	for _, kernel := range kernels {
+		generateMethod(&b, typeName, kernel)
+	}
+
+	result, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %s", err)
+	}
+	return result, nil
+}
+
+func generateMethod(b *bytes.Buffer, typeName string, kernel signature.KernelSignature) {
+
+	params := make([]string, len(kernel.Args))
+	types := make([]string, len(kernel.Args))
+	used := make(map[string]bool)
+	for i, arg := range kernel.Args {
+		types[i] = goArgType(&arg)
+
+		name := arg.Name
+		for reservedNames[name] || goKeywords[name] || used[name] {
+			name += "_"
+		}
+		used[name] = true
+		params[i] = name
+	}
+
+	fmt.Fprintf(b, "\n// Sets the arguments of the %s kernel and enqueues it.\n", kernel.Name)
+	fmt.Fprintf(b, "func (k *%s) %s(q *cl.CommandQueue, globalSize, localSize []int", typeName,
+		exportedName(kernel.Name))
+	for i := range params {
+		fmt.Fprintf(b, ", %s %s", params[i], types[i])
+	}
+	fmt.Fprintf(b, ", waitList []*cl.Event, e *cl.Event) error {\n")
+	for i := range params {
+		fmt.Fprintf(b, "\tif err := k.%s.SetArg(%d, %s); err != nil {\n\t\treturn err\n\t}\n",
+			kernelField(kernel.Name), i, params[i])
+	}
+	fmt.Fprintf(b, "\treturn q.EnqueueNDRangeKernel(k.%s, nil, globalSize, localSize, waitList, e)\n}\n",
+		kernelField(kernel.Name))
+}
+
+// The Go type used for a kernel argument.
+func goArgType(arg *signature.KernelArg) string {
+
+	switch {
+	case arg.IsImage():
+		return "*cl.Image"
+	case arg.IsSampler():
+		return "*cl.Sampler"
+	case arg.Pointer && arg.AddressSpace == signature.LocalSpace:
+		return "cl.LocalSpaceArg"
+	case arg.Pointer:
+		return "*cl.Buffer"
+	}
+
+	if goType := arg.GoType(); goType != nil {
+		return goType.String()
+	}
+
+	return "interface{}"
+}
+
+// Converts a kernel name such as "to_gray" or "toGray" to "ToGray".
+func exportedName(name string) string {
+	var result []rune
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		return "Kernel"
+	}
+	return string(result)
+}
+
+// The unexported struct field holding a kernel.
+func kernelField(name string) string {
+	return lowerFirst(exportedName(name)) + "Kernel"
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
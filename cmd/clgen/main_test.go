@@ -0,0 +1,64 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+var testSource = `
+__kernel void to_gray(read_only image2d_t src, write_only image2d_t dst, sampler_t s)
+{
+}
+
+__kernel void saxpy(float a, __global const float* x, __global float* y, __local float4* tmp, int4 range)
+{
+}
+`
+
+func TestGenerate(t *testing.T) {
+	result, err := generate("kernels", "Kernels", []clFile{{"test.cl", []byte(testSource)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parser.ParseFile(token.NewFileSet(), "kernels_cl.go", result, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"func NewKernels(c *cl.Context, d []*cl.Device, options string) (*Kernels, error)",
+		"func (k *Kernels) ToGray(q *cl.CommandQueue, globalSize, localSize []int, src *cl.Image, dst *cl.Image, " +
+			"s *cl.Sampler,",
+		"func (k *Kernels) Saxpy(q *cl.CommandQueue, globalSize, localSize []int, a float32, x *cl.Buffer, " +
+			"y *cl.Buffer, tmp cl.LocalSpaceArg, range_ [4]int32, waitList []*cl.Event, e *cl.Event) error",
+		`k.toGrayKernel, err = p.CreateKernel("to_gray")`,
+		"k.saxpyKernel.SetArg(4, range_)",
+	} {
+		if !strings.Contains(string(result), want) {
+			t.Errorf("generated code does not contain %q\n%s", want, result)
+		}
+	}
+}
+
+func TestGenerateDuplicateKernel(t *testing.T) {
+	_, err := generate("kernels", "Kernels", []clFile{
+		{"a.cl", []byte("__kernel void k(int a) {}")},
+		{"b.cl", []byte("__kernel void k(int a) {}")},
+	})
+	if err == nil {
+		t.Error("expected error for duplicate kernel")
+	}
+}
+
+func TestGenerateCollidingKernels(t *testing.T) {
+	_, err := generate("kernels", "Kernels", []clFile{
+		{"a.cl", []byte("__kernel void to_gray(int a) {}")},
+		{"b.cl", []byte("__kernel void toGray(int a) {}")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "to_gray in a.cl") {
+		t.Error("expected error for colliding kernels, got", err)
+	}
+}
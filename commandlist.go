@@ -0,0 +1,487 @@
+package cl11
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// A CommandList records a sequence of kernel launches and buffer copies that
+// can be replayed on any command queue of the same context, avoiding the cost
+// of setting up the same commands every frame.
+//
+// Kernel arguments are snapshotted when a command is recorded. Arguments and
+// buffers that change between replays are given as parameter slots, created
+// with NewParam and updated with SetParam. Dependencies between recorded
+// commands are expressed with the Command values returned by the Record
+// methods, so lists can be replayed on out-of-order queues.
+//
+// Only kernel launches (RecordNDRangeKernel and RecordTask) and copies between
+// buffers (RecordCopyBuffer) can be recorded. Other commands, such as
+// rectangular or image copies and maps, must be enqueued on the queue around
+// replays, using the replay's events to order them.
+//
+// Replay passes the recorded commands directly to the implementation, so it
+// refuses queues with a Profiler or Interceptors, which would not see them.
+// Replay does not allocate beyond converting its wait list. A CommandList is
+// not safe for concurrent use.
+type CommandList struct {
+	commands []*recordedCommand
+	params   []argValue
+}
+
+// A recorded command, used to declare dependencies.
+type Command int
+
+// A parameter slot of a CommandList. It can be used in place of a kernel
+// argument or a buffer when recording.
+type Param int
+
+type recordedCommand struct {
+	commandType CommandType
+
+	// Kernel commands.
+	kernel                *Kernel
+	args                  []argValue
+	offset, global, local []clw.Size
+
+	// Copy commands.
+	src, dst                   argValue
+	srcOffset, dstOffset, size clw.Size
+
+	deps     []Command
+	waitList []clw.Event
+
+	// The event of the last replay, only created if other commands depend on
+	// this one.
+	event      clw.Event
+	needsEvent bool
+}
+
+// A kernel argument or buffer, either fixed when recorded or a parameter.
+type argValue struct {
+	param   int // Index of the parameter, -1 if fixed.
+	buffer  *Buffer
+	image   *Image
+	sampler *Sampler
+	local   LocalSpaceArg
+	bytes   []byte
+
+	// The argument as Kernel.SetArg records it, so replays keep the kernel's
+	// arguments current.
+	value interface{}
+}
+
+// True if the argument is a LocalSpaceArg.
+func (v *argValue) isLocal() bool {
+	return v.buffer == nil && v.image == nil && v.sampler == nil && v.bytes == nil
+}
+
+// Creates an empty command list.
+func NewCommandList() *CommandList {
+	return &CommandList{}
+}
+
+// Creates a parameter slot with an initial value, which may be a *Buffer, an
+// *Image, a *Sampler, a LocalSpaceArg or a scalar value accepted by
+// Kernel.SetArg.
+func (cl *CommandList) NewParam(initial interface{}) (Param, error) {
+	if _, ok := initial.(Param); ok {
+		return 0, fmt.Errorf("cl: CommandList.NewParam: a parameter can not be initialized with a parameter")
+	}
+	value, err := cl.argValue(initial)
+	if err != nil {
+		return 0, err
+	}
+	cl.params = append(cl.params, value)
+	return Param(len(cl.params) - 1), nil
+}
+
+// Sets the value a parameter has in subsequent replays. The value must be of
+// the same kind and, for scalars, the same size as the initial value.
+func (cl *CommandList) SetParam(p Param, value interface{}) error {
+
+	if p < 0 || int(p) >= len(cl.params) {
+		return fmt.Errorf("cl: CommandList.SetParam: invalid parameter %d", p)
+	}
+
+	current := &cl.params[p]
+	switch v := value.(type) {
+	case *Buffer:
+		if current.buffer == nil {
+			return fmt.Errorf("cl: CommandList.SetParam: parameter %d is not a buffer", p)
+		}
+		current.buffer, current.value = v, value
+		return nil
+	case *Image:
+		if current.image == nil {
+			return fmt.Errorf("cl: CommandList.SetParam: parameter %d is not an image", p)
+		}
+		current.image, current.value = v, value
+		return nil
+	case *Sampler:
+		if current.sampler == nil {
+			return fmt.Errorf("cl: CommandList.SetParam: parameter %d is not a sampler", p)
+		}
+		current.sampler, current.value = v, value
+		return nil
+	case LocalSpaceArg:
+		if !current.isLocal() {
+			return fmt.Errorf("cl: CommandList.SetParam: parameter %d is not a local space argument", p)
+		}
+		current.local, current.value = v, value
+		return nil
+	}
+
+	if current.bytes == nil {
+		return fmt.Errorf("cl: CommandList.SetParam: parameter %d is not a scalar", p)
+	}
+	err := setScalarBytes(current.bytes, value)
+	if err == nil {
+		current.value = scalarValue(value)
+	}
+	return err
+}
+
+// Records a kernel launch, see EnqueueNDRangeKernel. If args is nil the
+// kernel's current arguments (those set with SetArg) are snapshotted,
+// otherwise args gives every argument and may contain parameters.
+func (cl *CommandList) RecordNDRangeKernel(k *Kernel, args []interface{}, globalOffset, globalSize,
+	localSize []int, deps ...Command) (Command, error) {
+
+	c, err := cl.kernelCommand(CommandNDRangeKernel, k, args, deps)
+	if err != nil {
+		return 0, err
+	}
+
+	dims := len(globalSize)
+	sizes := make([]clw.Size, dims*3)
+	for i := 0; i < dims; i++ {
+		if globalOffset != nil {
+			sizes[i] = clw.Size(globalOffset[i])
+		}
+		sizes[dims+i] = clw.Size(globalSize[i])
+		if localSize != nil {
+			sizes[2*dims+i] = clw.Size(localSize[i])
+		}
+	}
+	c.offset, c.global = sizes[:dims], sizes[dims:2*dims]
+	if localSize != nil {
+		c.local = sizes[2*dims:]
+	}
+
+	return cl.add(c), nil
+}
+
+// Records a kernel executed by a single work-item, see EnqueueTask.
+func (cl *CommandList) RecordTask(k *Kernel, args []interface{}, deps ...Command) (Command, error) {
+	c, err := cl.kernelCommand(CommandTask, k, args, deps)
+	if err != nil {
+		return 0, err
+	}
+	return cl.add(c), nil
+}
+
+// Records a copy between buffers, see EnqueueCopyBuffer. The source and
+// destination are each either a *Buffer or a Param.
+func (cl *CommandList) RecordCopyBuffer(src, dst interface{}, srcOffset, dstOffset, size int64,
+	deps ...Command) (Command, error) {
+
+	c := &recordedCommand{
+		commandType: CommandCopyBuffer,
+		srcOffset:   clw.Size(srcOffset),
+		dstOffset:   clw.Size(dstOffset),
+		size:        clw.Size(size),
+		deps:        deps,
+	}
+
+	err := cl.checkDeps(deps)
+	if err != nil {
+		return 0, err
+	}
+	c.src, err = cl.bufferValue(src)
+	if err != nil {
+		return 0, err
+	}
+	c.dst, err = cl.bufferValue(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	return cl.add(c), nil
+}
+
+func (cl *CommandList) kernelCommand(commandType CommandType, k *Kernel, args []interface{},
+	deps []Command) (*recordedCommand, error) {
+
+	err := cl.checkDeps(deps)
+	if err != nil {
+		return nil, err
+	}
+
+	if args == nil {
+		args = k.args
+	}
+	if len(args) != k.Arguments {
+		return nil, fmt.Errorf("cl: CommandList: kernel %s takes %d arguments, got %d", k.FunctionName,
+			k.Arguments, len(args))
+	}
+
+	c := &recordedCommand{commandType: commandType, kernel: k, args: make([]argValue, len(args)), deps: deps}
+	for i, arg := range args {
+		if arg == nil {
+			return nil, fmt.Errorf("cl: CommandList: kernel %s argument %d is not set", k.FunctionName, i)
+		}
+		c.args[i], err = cl.argValue(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (cl *CommandList) checkDeps(deps []Command) error {
+	for _, d := range deps {
+		if d < 0 || int(d) >= len(cl.commands) {
+			return fmt.Errorf("cl: CommandList: invalid dependency %d", d)
+		}
+	}
+	return nil
+}
+
+func (cl *CommandList) add(c *recordedCommand) Command {
+	c.waitList = make([]clw.Event, 0, len(c.deps))
+	for _, d := range c.deps {
+		cl.commands[d].needsEvent = true
+	}
+	cl.commands = append(cl.commands, c)
+	return Command(len(cl.commands) - 1)
+}
+
+func (cl *CommandList) bufferValue(v interface{}) (argValue, error) {
+	switch b := v.(type) {
+	case *Buffer:
+		return argValue{param: -1, buffer: b}, nil
+	case Param:
+		if b < 0 || int(b) >= len(cl.params) || cl.params[b].buffer == nil {
+			return argValue{}, fmt.Errorf("cl: CommandList: parameter %d is not a buffer", b)
+		}
+		return argValue{param: int(b)}, nil
+	}
+	return argValue{}, fmt.Errorf("cl: CommandList: %T is not a buffer", v)
+}
+
+// Snapshots a kernel argument.
+func (cl *CommandList) argValue(arg interface{}) (argValue, error) {
+
+	switch v := arg.(type) {
+	case *Buffer:
+		return argValue{param: -1, buffer: v, value: arg}, nil
+	case *Image:
+		return argValue{param: -1, image: v, value: arg}, nil
+	case *Sampler:
+		return argValue{param: -1, sampler: v, value: arg}, nil
+	case LocalSpaceArg:
+		return argValue{param: -1, local: v, value: arg}, nil
+	case Param:
+		if int(v) < 0 || int(v) >= len(cl.params) {
+			return argValue{}, fmt.Errorf("cl: CommandList: invalid parameter %d", v)
+		}
+		return argValue{param: int(v)}, nil
+	}
+
+	value := reflect.ValueOf(arg)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	b := make([]byte, value.Type().Size())
+	return argValue{param: -1, bytes: b, value: scalarValue(arg)}, setScalarBytes(b, arg)
+}
+
+// Returns a scalar argument as Kernel.SetArg records it, the value rather than
+// what it points to. Common types are returned as is, without allocating.
+func scalarValue(arg interface{}) interface{} {
+	switch arg.(type) {
+	case int32, uint32, int64, uint64, float32, float64, int:
+		return arg
+	}
+	value := reflect.ValueOf(arg)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	return value.Interface()
+}
+
+// Copies the memory of a scalar value, or what a pointer points to, into b.
+// Common types are handled without allocating.
+func setScalarBytes(b []byte, arg interface{}) error {
+
+	var pointer unsafe.Pointer
+	var size uintptr
+	switch v := arg.(type) {
+	case int32:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case uint32:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case int64:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case uint64:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case float32:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case float64:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	case int:
+		pointer, size = unsafe.Pointer(&v), unsafe.Sizeof(v)
+	default:
+		value := reflect.ValueOf(arg)
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			value = value.Elem()
+		}
+		if !value.CanAddr() {
+			addressable := reflect.New(value.Type()).Elem()
+			addressable.Set(value)
+			value = addressable
+		}
+		pointer, size = unsafe.Pointer(value.UnsafeAddr()), value.Type().Size()
+	}
+
+	if size != uintptr(len(b)) {
+		return fmt.Errorf("cl: CommandList: %T is %d bytes, want %d", arg, size, len(b))
+	}
+	copy(b, (*[1 << 30]byte)(pointer)[:size:size])
+	return nil
+}
+
+// Returns the current value of an argument.
+func (cl *CommandList) resolve(v *argValue) *argValue {
+	if v.param >= 0 {
+		return &cl.params[v.param]
+	}
+	return v
+}
+
+// Sets the kernel's arguments for a command, recording them in the kernel as
+// SetArg does.
+func (cl *CommandList) setKernelArgs(c *recordedCommand) error {
+	k := c.kernel
+	if len(k.args) < len(c.args) {
+		args := make([]interface{}, len(c.args))
+		copy(args, k.args)
+		k.args = args
+	}
+	for i := range c.args {
+		v := cl.resolve(&c.args[i])
+		var err error
+		switch {
+		case v.buffer != nil:
+			err = clw.SetKernelArg(k.id, clw.Uint(i), clw.Size(unsafe.Sizeof(v.buffer.id)),
+				unsafe.Pointer(&v.buffer.id))
+		case v.image != nil:
+			err = clw.SetKernelArg(k.id, clw.Uint(i), clw.Size(unsafe.Sizeof(v.image.id)),
+				unsafe.Pointer(&v.image.id))
+		case v.sampler != nil:
+			err = clw.SetKernelArg(k.id, clw.Uint(i), clw.Size(unsafe.Sizeof(v.sampler.id)),
+				unsafe.Pointer(&v.sampler.id))
+		case v.bytes != nil:
+			err = clw.SetKernelArg(k.id, clw.Uint(i), clw.Size(len(v.bytes)), unsafe.Pointer(&v.bytes[0]))
+		default:
+			err = clw.SetKernelArg(k.id, clw.Uint(i), clw.Size(v.local), nil)
+		}
+		if err != nil {
+			return err
+		}
+		k.args[i] = v.value
+	}
+	return nil
+}
+
+// Enqueues the recorded commands on the command queue with the current
+// parameter values.
+//
+// Commands without dependencies wait on waitList. If e is not nil it is the
+// event of a marker enqueued after the commands, which completes once they
+// have all completed. The events of the previous replay are released, so a
+// replay must have been enqueued before the next begins but need not have
+// completed.
+func (cl *CommandList) Replay(cq *CommandQueue, waitList []*Event, e *Event) error {
+
+	if cq.Profiler != nil || len(cq.Interceptors) > 0 {
+		return fmt.Errorf("cl: CommandList.Replay: the command queue has a Profiler or Interceptors")
+	}
+
+	cl.releaseEvents()
+
+	external := cq.createEvents(waitList)
+	defer cq.releaseEvents(external)
+
+	for _, c := range cl.commands {
+
+		events := external
+		if len(c.deps) > 0 {
+			events = c.waitList[:0]
+			for _, d := range c.deps {
+				events = append(events, cl.commands[d].event)
+			}
+		}
+		if len(events) == 0 {
+			events = nil
+		}
+
+		var event *clw.Event
+		if c.needsEvent {
+			event = &c.event
+		}
+
+		var err error
+		switch c.commandType {
+		case CommandNDRangeKernel:
+			err = cl.setKernelArgs(c)
+			if err == nil {
+				err = clw.EnqueueNDRangeKernel(cq.id, c.kernel.id, c.offset, c.global, c.local, events, event)
+			}
+		case CommandTask:
+			err = cl.setKernelArgs(c)
+			if err == nil {
+				err = clw.EnqueueTask(cq.id, c.kernel.id, events, event)
+			}
+		case CommandCopyBuffer:
+			err = clw.EnqueueCopyBuffer(cq.id, cl.resolve(&c.src).buffer.id, cl.resolve(&c.dst).buffer.id,
+				c.srcOffset, c.dstOffset, c.size, events, event)
+		default:
+			err = fmt.Errorf("cl: CommandList.Replay: %v commands can not be replayed", c.commandType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if e != nil {
+		return cq.EnqueueMarker(e)
+	}
+	return nil
+}
+
+// Releases the events of the last replay.
+func (cl *CommandList) releaseEvents() error {
+	var err error
+	var zero clw.Event
+	for _, c := range cl.commands {
+		if c.event != zero {
+			if releaseErr := clw.ReleaseEvent(c.event); err == nil {
+				err = releaseErr
+			}
+			c.event = zero
+		}
+	}
+	return err
+}
+
+// Releases the events the list holds from the last replay. The list can still
+// be replayed.
+func (cl *CommandList) Release() error {
+	return cl.releaseEvents()
+}
@@ -0,0 +1,215 @@
+package cl11
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCommandListParams(t *testing.T) {
+	cl := NewCommandList()
+
+	scale, err := cl.NewParam(float32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer, err := cl.NewParam(&Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value interface{} = float32(2)
+	allocs := testing.AllocsPerRun(100, func() {
+		err = cl.SetParam(scale, value)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if allocs != 0 {
+		t.Errorf("SetParam made %v allocations", allocs)
+	}
+	if got := binary.LittleEndian.Uint32(cl.params[scale].bytes); got != 0x40000000 {
+		t.Errorf("got bytes %#x, want %#x", got, 0x40000000)
+	}
+	if got := cl.params[scale].value; got != float32(2) {
+		t.Errorf("got value %v, want 2", got)
+	}
+	if err = cl.SetParam(scale, new(float32)); err != nil || cl.params[scale].value != float32(0) {
+		t.Error("unexpected value set through a pointer", cl.params[scale].value, err)
+	}
+
+	if cl.SetParam(scale, float64(1)) == nil {
+		t.Error("expected error setting a parameter to a different size")
+	}
+	if cl.SetParam(scale, &Buffer{}) == nil {
+		t.Error("expected error setting a scalar parameter to a buffer")
+	}
+	if cl.SetParam(buffer, int32(1)) == nil {
+		t.Error("expected error setting a buffer parameter to a scalar")
+	}
+	if cl.SetParam(scale, LocalSpaceArg(64)) == nil {
+		t.Error("expected error setting a scalar parameter to local space")
+	}
+	if cl.SetParam(Param(len(cl.params)), int32(1)) == nil || cl.SetParam(-1, int32(1)) == nil {
+		t.Error("expected error setting an invalid parameter")
+	}
+
+	local, err := cl.NewParam(LocalSpaceArg(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.SetParam(local, LocalSpaceArg(64)); err != nil || cl.params[local].local != 64 {
+		t.Error("unexpected local space parameter", cl.params[local].local, err)
+	}
+	if cl.SetParam(local, int32(1)) == nil {
+		t.Error("expected error setting a local space parameter to a scalar")
+	}
+
+	src, dst := &Buffer{}, &Buffer{}
+	first, err := cl.RecordCopyBuffer(src, buffer, 0, 0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cl.RecordCopyBuffer(buffer, dst, 0, 0, 16, first); err != nil {
+		t.Error(err)
+	}
+	if !cl.commands[first].needsEvent {
+		t.Error("dependency does not create an event")
+	}
+	if _, err = cl.RecordCopyBuffer(src, dst, 0, 0, 16, 5); err == nil {
+		t.Error("expected error for an invalid dependency")
+	}
+	if _, err = cl.RecordCopyBuffer(src, scale, 0, 0, 16); err == nil {
+		t.Error("expected error copying to a scalar parameter")
+	}
+
+	if err = cl.Replay(&CommandQueue{Profiler: &Profiler{}}, nil, nil); err == nil {
+		t.Error("expected error replaying on a profiled queue")
+	}
+}
+
+func TestCommandListReplay(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 1024
+		size := int64(elements * 4)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		var buffers []*Buffer
+		for i := 0; i < 3; i++ {
+			b, err := ctx.CreateHostBuffer(size, MemReadWrite)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			toRelease = append(toRelease, b)
+			buffers = append(buffers, b)
+		}
+		if len(buffers) != 3 {
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		cl := NewCommandList()
+		dst, err := cl.NewParam(buffers[1])
+		if err != nil {
+			t.Error(err)
+		}
+		index, err := cl.RecordNDRangeKernel(kernel, []interface{}{buffers[0]}, nil, []int{elements}, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = cl.RecordCopyBuffer(buffers[0], dst, 0, 0, size, index)
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, b := range buffers[1:] {
+			err = cl.SetParam(dst, b)
+			if err != nil {
+				t.Error(err)
+			}
+			var done Event
+			err = cl.Replay(cq, nil, &done)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			err = done.Wait()
+			if err != nil {
+				t.Error(err)
+			}
+			done.Release()
+
+			mapped, err := cq.EnqueueMapBuffer(b, Blocking, MapRead, 0, size, nil, nil)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			data := mapped.Bytes()
+			for i := 0; i < elements; i++ {
+				if v := binary.LittleEndian.Uint32(data[i*4:]); v != uint32(i) {
+					t.Errorf("element %d is %d", i, v)
+					break
+				}
+			}
+			err = cq.EnqueueUnmapBuffer(mapped, nil, nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		if len(kernel.args) != 1 || kernel.args[0] != buffers[0] {
+			t.Error("replay did not record the kernel's arguments", kernel.args)
+		}
+
+		err = cq.Finish()
+		if err != nil {
+			t.Error(err)
+		}
+		err = cl.Release()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll(toRelease, t)
+	}
+}
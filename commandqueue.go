@@ -37,6 +37,16 @@ type CommandQueue struct {
 	// Bit-field list of properties for the command queue.
 	Properties CommandQueueProperties
 
+	// If not nil, EnqueueNDRangeKernel uses the tuned work-group size when
+	// localSize is omitted.
+	Autotuner *Autotuner
+
+	// If not nil, every enqueued command is recorded, see NewProfiler.
+	Profiler *Profiler
+
+	// Called around every Enqueue method, see AddInterceptor.
+	Interceptors []Interceptor
+
 	// Pool used when converting a wait list.
 	eventPool sync.Pool
 }
@@ -134,13 +144,24 @@ func (cq *CommandQueue) ReferenceCount() (int, error) {
 // command returns an event which can be waited on.
 func (cq *CommandQueue) EnqueueMarker(e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandMarker, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		e = info.Event
+	}
+
 	if e != nil {
 		e.Context = cq.Context
 		e.CommandType = CommandMarker
 		e.CommandQueue = cq
 	}
 
-	return clw.EnqueueMarker(cq.id, &e.id)
+	err := clw.EnqueueMarker(cq.id, &e.id)
+	cq.profileCommand(e, false, err, "", 0)
+	return cq.interceptAfter(info, err)
 }
 
 // Enqueues a wait for a specific event or a list of events to complete before
@@ -150,10 +171,19 @@ func (cq *CommandQueue) EnqueueMarker(e *Event) error {
 // the same.
 func (cq *CommandQueue) EnqueueWaitForEvents(waitList []*Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{WaitList: waitList}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		waitList = info.WaitList
+	}
+
 	events := cq.createEvents(waitList)
 	err := clw.EnqueueWaitForEvents(cq.id, events)
 	cq.releaseEvents(events)
-	return err
+	return cq.interceptAfter(info, err)
 }
 
 // A synchronization point that enqueues a barrier operation.
@@ -162,15 +192,25 @@ func (cq *CommandQueue) EnqueueWaitForEvents(waitList []*Event) error {
 // commands in command_queue have finished execution before the next batch of
 // commands can begin execution.
 func (cq *CommandQueue) EnqueueBarrier() error {
-	return clw.EnqueueBarrier(cq.id)
+
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+	}
+
+	return cq.interceptAfter(info, clw.EnqueueBarrier(cq.id))
 }
 
 func (cq *CommandQueue) createEvents(waitList []*Event) []clw.Event {
 	var result []clw.Event
 	if len(waitList) > eventPoolThreshold {
 		result = make([]clw.Event, len(waitList))
+	} else {
+		result = cq.eventPool.Get().([]clw.Event)[:len(waitList)]
 	}
-	result = cq.eventPool.Get().([]clw.Event)[:len(waitList)]
 	for i, v := range waitList {
 		result[i] = v.id
 	}
@@ -91,9 +91,21 @@ func CreateContextFromType(cp []ContextProperties, dt DeviceType, cc ContextCall
 		return nil, err
 	}
 
+	c, err := contextFromID(context)
+	if err != nil {
+		return nil, err
+	}
+	c.Properties = cp
+
+	return c, nil
+}
+
+// Creates a context for an existing context ID, querying its devices.
+func contextFromID(context clw.Context) (*Context, error) {
+
 	// Get devices.
 	var numDevices clw.Uint
-	err = clw.GetContextInfo(context, clw.ContextNumDevices, clw.Size(unsafe.Sizeof(numDevices)),
+	err := clw.GetContextInfo(context, clw.ContextNumDevices, clw.Size(unsafe.Sizeof(numDevices)),
 		unsafe.Pointer(&numDevices), nil)
 	if err != nil {
 		return nil, err
@@ -121,9 +133,8 @@ func CreateContextFromType(cp []ContextProperties, dt DeviceType, cc ContextCall
 	}
 
 	return &Context{
-		id:         context,
-		Devices:    devicePtrs,
-		Properties: cp,
+		id:      context,
+		Devices: devicePtrs,
 	}, nil
 }
 
@@ -18,6 +18,36 @@ var ErrUnsupportedImageFormat = errors.New("cl: unsupported image format")
 // ErrBufferFull is returned when trying to write past the end of a buffer.
 var ErrBufferFull = errors.New("cl: buffer full")
 
+// ErrInvalidChunkSize is returned when an ND range can not be split into
+// launches of the requested number of work-items, i.e. a single work-group is
+// larger than the maximum.
+var ErrInvalidChunkSize = errors.New("cl: invalid chunk size")
+
+// ErrNativeKernelUnsupported is returned when enqueuing a native kernel on a
+// device that can not execute native kernels.
+var ErrNativeKernelUnsupported = errors.New("cl: device does not support native kernels")
+
+// ErrNoProgramSource is returned when information that is derived from the
+// program source is requested for a program without source, e.g. one created
+// from binaries.
+var ErrNoProgramSource = errors.New("cl: program has no source")
+
+// ErrInvalidProgramBinary is returned when unmarshaling data that is not a
+// valid program binary container or bundle, or that has been corrupted.
+var ErrInvalidProgramBinary = errors.New("cl: invalid program binary container")
+
+// ErrNoMatchingBinary is returned when a bundle does not contain an up to date
+// binary for a device.
+var ErrNoMatchingBinary = errors.New("cl: no matching program binary")
+
+// ErrGraphDependency is returned when a graph node depends on a node of another
+// graph.
+var ErrGraphDependency = errors.New("cl: graph dependency is not in the graph")
+
+// ErrEmptyEventSet is returned when waiting for any event of an empty event
+// set.
+var ErrEmptyEventSet = errors.New("cl: empty event set")
+
 var (
 	DeviceNotFound                     = clw.DeviceNotFound
 	DeviceNotAvailable                 = clw.DeviceNotAvailable
@@ -35,6 +65,10 @@ var (
 	ExecStatusErrorForEventsInWaitList = clw.ExecStatusErrorForEventsInWaitList
 )
 
+// The status code of ExecStatusErrorForEventsInWaitList, for Event.SetError,
+// which takes a code rather than an error.
+const execStatusErrorForEventsInWaitListCode = -14
+
 var (
 	InvalidValue                 = clw.InvalidValue
 	InvalidDeviceType            = clw.InvalidDeviceType
@@ -1,7 +1,9 @@
 package cl11
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"unsafe"
 
 	clw "github.com/rdwilliamson/clw11"
@@ -30,8 +32,21 @@ type Event struct {
 	Submit int64 // Profiling information.
 	Start  int64 // Profiling information.
 	End    int64 // Profiling information.
+
+	// Signals completion of the command, created by the first call to Done or
+	// Err.
+	completion *eventCompletion
+}
+
+type eventCompletion struct {
+	id   clw.Event
+	done chan struct{}
+	err  error
 }
 
+// Guards the creation of event completions.
+var eventCompletionMu sync.Mutex
+
 // Differs from clw.EventCallbackFunc because the command execution status is
 // either Complete (success) or an error.
 type EventCallback func(e *Event, err error, userData interface{})
@@ -192,31 +207,88 @@ func (e *Event) GetProfilingInfo() error {
 	return nil
 }
 
-// Registers a user callback function for a specific command execution status.
+// Registers a user callback function for when the command completes.
 //
 // The registered callback function will be called when the execution status of
 // command associated with event changes to Complete or is abnormally terminated
-// due to an error.
+// due to an error. See SetStatusCallback.
+func (e *Event) SetCallback(callback EventCallback, userData interface{}) error {
+	return e.SetStatusCallback(Complete, callback, userData)
+}
+
+// Registers a user callback function for a specific command execution status.
+//
+// The registered callback function will be called when the execution status of
+// command associated with event changes to status or is abnormally terminated
+// due to an error. OpenCL 1.1 only supports Complete, InvalidValue is returned
+// for any other status. The error passed to the callback is nil unless the
+// command was terminated.
 //
-// Each call to SetCallback registers the specified user callback
+// Each call to SetStatusCallback registers the specified user callback
 // function on a callback stack associated with event. The order in which the
-// registered user callback functions are called is undefined.
+// registered user callback functions are called is undefined. The callback and
+// user data are referenced until the callback has been called, which happens at
+// the latest when the event reaches a terminal state.
 //
 // All callbacks registered for an event object must be called. All enqueued
 // callbacks shall be called before the event object is destroyed. Callbacks
 // must return promptly. The behavior of calling expensive system routines,
 // OpenCL API calls to create contexts or command-queues, or blocking OpenCL
 // operations, in a callback is undefined.
-func (e *Event) SetCallback(callback EventCallback, userData interface{}) error {
-	return clw.SetEventCallback(e.id, clw.Complete,
-		func(event clw.Event, ces clw.CommandExecutionStatus, _userData interface{}) {
-			var err error
-			if ces < 0 {
-				err = clw.CodeToError(clw.Int(ces))
-			}
-			callback(e, err, _userData)
-		},
-		userData)
+func (e *Event) SetStatusCallback(status CommandExecutionStatus, callback EventCallback,
+	userData interface{}) error {
+
+	if status != Complete {
+		return InvalidValue
+	}
+
+	eventCallbacks.Lock()
+	eventCallbacks.next++
+	key := eventCallbacks.next
+	eventCallbacks.callbacks[key] = &eventCallback{event: e, callback: callback, userData: userData}
+	eventCallbacks.Unlock()
+
+	err := clw.SetEventCallback(e.id, clw.CommandExecutionStatus(status), eventStatusChanged, key)
+	if err != nil {
+		eventCallbacks.Lock()
+		delete(eventCallbacks.callbacks, key)
+		eventCallbacks.Unlock()
+	}
+	return err
+}
+
+type eventCallback struct {
+	event    *Event
+	callback EventCallback
+	userData interface{}
+}
+
+// Registered event callbacks that have not been called yet, keyed by the user
+// data passed with eventStatusChanged. The implementation calls each callback
+// exactly once, so only the key of a called callback remains referenced.
+var eventCallbacks = struct {
+	sync.Mutex
+	next      uintptr
+	callbacks map[uintptr]*eventCallback
+}{callbacks: make(map[uintptr]*eventCallback)}
+
+// The single callback registered for all events.
+func eventStatusChanged(_ clw.Event, ces clw.CommandExecutionStatus, userData interface{}) {
+
+	key := userData.(uintptr)
+	eventCallbacks.Lock()
+	ec := eventCallbacks.callbacks[key]
+	delete(eventCallbacks.callbacks, key)
+	eventCallbacks.Unlock()
+	if ec == nil {
+		return
+	}
+
+	var err error
+	if ces < 0 {
+		err = clw.CodeToError(clw.Int(ces))
+	}
+	ec.callback(ec.event, err, ec.userData)
 }
 
 // Waits on the host thread for the event to complete. See WaitForEvents for
@@ -225,6 +297,50 @@ func (e *Event) Wait() error {
 	return clw.WaitForEvents([]clw.Event{e.id})
 }
 
+// Returns a channel that is closed when the command identified by the event
+// completes or is abnormally terminated, see Err. A callback is registered with
+// the event the first time Done or Err is called for the command.
+func (e *Event) Done() <-chan struct{} {
+	return e.getCompletion().done
+}
+
+// Returns nil if the command has not finished or completed successfully, and
+// the error that terminated it otherwise. It is non-blocking, use Done to wait.
+func (e *Event) Err() error {
+	c := e.getCompletion()
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (e *Event) getCompletion() *eventCompletion {
+
+	eventCompletionMu.Lock()
+	defer eventCompletionMu.Unlock()
+
+	// The event may have been reused for another command.
+	if e.completion != nil && e.completion.id == e.id {
+		return e.completion
+	}
+
+	c := &eventCompletion{id: e.id, done: make(chan struct{})}
+	e.completion = c
+
+	err := e.SetCallback(func(e *Event, err error, userData interface{}) {
+		c.err = err
+		close(c.done)
+	}, nil)
+	if err != nil {
+		c.err = err
+		close(c.done)
+	}
+
+	return c
+}
+
 // Waits on the host thread for commands identified by event objects to
 // complete.
 //
@@ -245,6 +361,30 @@ func WaitForEvents(events ...*Event) error {
 	return clw.WaitForEvents(waitList)
 }
 
+// Waits for the commands identified by the events to complete like
+// WaitForEvents, but returns ctx.Err() early if the context is done first. The
+// commands are not affected by the cancellation.
+func WaitForEventsContext(ctx context.Context, events ...*Event) error {
+
+	if ctx.Done() == nil {
+		return WaitForEvents(events...)
+	}
+
+	var err error
+	for _, e := range events {
+		select {
+		case <-e.Done():
+			if e.Err() != nil {
+				err = ExecStatusErrorForEventsInWaitList
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
 // Creates a user event object.
 //
 // User events allow applications to enqueue commands that wait on a user event
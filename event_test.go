@@ -0,0 +1,153 @@
+package cl11
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventDone(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		complete, err := ctx.CreateUserEvent()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, complete)
+
+		failed, err := ctx.CreateUserEvent()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, failed)
+
+		select {
+		case <-complete.Done():
+			t.Error("Done closed before completion")
+		default:
+		}
+
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = WaitForEventsContext(timeout, complete, failed)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Error("expected deadline exceeded, got", err)
+		}
+
+		err = complete.SetComplete()
+		if err != nil {
+			t.Error(err)
+		}
+		err = failed.SetError(-30) // CL_INVALID_VALUE
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = WaitForEventsContext(context.Background(), complete, failed)
+		if err != ExecStatusErrorForEventsInWaitList {
+			t.Error("expected ExecStatusErrorForEventsInWaitList, got", err)
+		}
+		if complete.Err() != nil {
+			t.Error(complete.Err())
+		}
+		if failed.Err() == nil {
+			t.Error("expected error for failed event")
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+func TestEventStatusCallbackStatus(t *testing.T) {
+	var e Event
+	for _, status := range []CommandExecutionStatus{Queued, Submitted, Running} {
+		err := e.SetStatusCallback(status, func(*Event, error, interface{}) {}, nil)
+		if err != InvalidValue {
+			t.Error(status, "expected", InvalidValue, "got", err)
+		}
+	}
+}
+
+func TestEventStatusCallback(t *testing.T) {
+	registered := func() int {
+		eventCallbacks.Lock()
+		defer eventCallbacks.Unlock()
+		return len(eventCallbacks.callbacks)
+	}
+
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		e, err := ctx.CreateUserEvent()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, e)
+
+		before := registered()
+
+		err = e.SetStatusCallback(Queued, func(*Event, error, interface{}) {}, nil)
+		if err == nil {
+			t.Error("expected error registering a Queued callback")
+		}
+
+		called := make(chan interface{}, 1)
+		err = e.SetStatusCallback(Complete, func(event *Event, err error, userData interface{}) {
+			if event != e || err != nil {
+				t.Error("unexpected callback arguments", event, err)
+			}
+			called <- userData
+		}, "data")
+		if err != nil {
+			t.Error(err)
+		}
+		if n := registered(); n != before+1 {
+			t.Errorf("%d callbacks registered, want %d", n, before+1)
+		}
+
+		err = e.SetComplete()
+		if err != nil {
+			t.Error(err)
+		}
+		select {
+		case userData := <-called:
+			if userData != "data" {
+				t.Error("got user data", userData)
+			}
+		case <-time.After(time.Second):
+			t.Error("callback not called")
+		}
+		if n := registered(); n != before {
+			t.Errorf("%d callbacks registered after completion, want %d", n, before)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
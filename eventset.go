@@ -0,0 +1,125 @@
+package cl11
+
+import (
+	"reflect"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// An EventSet owns a group of events, such as those of the commands enqueued to
+// handle a request, so they can be waited on and released together. The zero
+// value is an empty set.
+//
+// The set may hold events whose enqueue call failed, so it is not itself a
+// wait list. WaitList returns the events to wait on, call it in its own
+// statement before calling New for the command that waits so the new event is
+// never part of its own wait list.
+//
+//	var events cl.EventSet
+//	defer events.Release()
+//	err := cq.EnqueueCopyBuffer(src, dst, 0, 0, size, nil, events.New())
+//	...
+//	waitList := events.WaitList()
+//	err = cq.EnqueueNDRangeKernel(k, nil, global, nil, waitList, events.New())
+type EventSet struct {
+	events []*Event
+}
+
+// Returns a new event that belongs to the set, to pass to an enqueue call. If
+// the call fails the event is ignored by the set's methods.
+func (es *EventSet) New() *Event {
+	e := &Event{}
+	es.events = append(es.events, e)
+	return e
+}
+
+// Adds events to the set, which takes over the caller's references to them.
+func (es *EventSet) Add(events ...*Event) {
+	es.events = append(es.events, events...)
+}
+
+// True if the event refers to a command, i.e. the enqueue call it was passed to
+// succeeded.
+func (e *Event) valid() bool {
+	var zero clw.Event
+	return e.id != zero
+}
+
+// Returns the events that refer to commands, skipping those whose enqueue call
+// failed or has not been made yet, to pass as a wait list.
+func (es *EventSet) WaitList() []*Event {
+	events := make([]*Event, 0, len(es.events))
+	for _, e := range es.events {
+		if e.valid() {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Waits for all commands in the set to complete, see WaitForEvents.
+func (es *EventSet) Wait() error {
+	events := es.WaitList()
+	if len(events) == 0 {
+		return nil
+	}
+	return WaitForEvents(events...)
+}
+
+// Waits for any command in the set to complete and returns its event and the
+// error it terminated with, if any.
+func (es *EventSet) WaitAny() (*Event, error) {
+
+	events := es.WaitList()
+	if len(events) == 0 {
+		return nil, ErrEmptyEventSet
+	}
+
+	cases := make([]reflect.SelectCase, len(events))
+	for i, e := range events {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(e.Done())}
+	}
+	i, _, _ := reflect.Select(cases)
+
+	return events[i], events[i].Err()
+}
+
+// Returns the least advanced execution status of the commands in the set, so
+// Complete means all commands have completed. The eventErr is the error of the
+// first command that was abnormally terminated, see Event.Status.
+func (es *EventSet) Status() (ces CommandExecutionStatus, eventErr, getStatusErr error) {
+
+	ces = Complete
+	for _, e := range es.WaitList() {
+		status, err, getErr := e.Status()
+		if getErr != nil {
+			return 0, nil, getErr
+		}
+		if err != nil {
+			if eventErr == nil {
+				eventErr = err
+			}
+			continue
+		}
+		if status > ces {
+			ces = status
+		}
+	}
+
+	return ces, eventErr, nil
+}
+
+// Releases all events in the set and empties it. Intended to be deferred.
+func (es *EventSet) Release() error {
+	var err error
+	for _, e := range es.events {
+		if !e.valid() {
+			continue
+		}
+		if releaseErr := e.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	es.events = nil
+	return err
+}
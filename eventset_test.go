@@ -0,0 +1,170 @@
+package cl11
+
+import "testing"
+
+func TestEventSetNotEnqueued(t *testing.T) {
+	var es EventSet
+	es.New()
+	es.New()
+
+	if waitList := es.WaitList(); len(waitList) != 0 {
+		t.Error("expected an empty wait list, got", len(waitList))
+	}
+	if err := es.Wait(); err != nil {
+		t.Error(err)
+	}
+	if ces, eventErr, err := es.Status(); ces != Complete || eventErr != nil || err != nil {
+		t.Error("unexpected status", ces, eventErr, err)
+	}
+	if _, err := es.WaitAny(); err != ErrEmptyEventSet {
+		t.Error("expected ErrEmptyEventSet, got", err)
+	}
+	if err := es.Release(); err != nil || len(es.events) != 0 {
+		t.Error("unexpected release", err, len(es.events))
+	}
+}
+
+func TestEventSet(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		var es EventSet
+		var events []*Event
+		for i := 0; i < 3; i++ {
+			e, err := ctx.CreateUserEvent()
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			es.Add(e)
+			events = append(events, e)
+		}
+		if len(events) != 3 {
+			es.Release()
+			releaseAll([]Object{ctx}, t)
+			continue
+		}
+
+		if ces, eventErr, err := es.Status(); ces != Submitted || eventErr != nil || err != nil {
+			t.Error("unexpected status", ces, eventErr, err)
+		}
+
+		err = events[1].SetComplete()
+		if err != nil {
+			t.Error(err)
+		}
+		e, err := es.WaitAny()
+		if e != events[1] || err != nil {
+			t.Error("unexpected WaitAny result", e, err)
+		}
+
+		events[0].SetComplete()
+		events[2].SetError(-30) // CL_INVALID_VALUE
+		if err := es.Wait(); err == nil {
+			t.Error("expected error waiting for a failed event")
+		}
+		if ces, eventErr, err := es.Status(); ces != Complete || eventErr == nil || err != nil {
+			t.Error("unexpected status", ces, eventErr, err)
+		}
+
+		err = es.Release()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll([]Object{ctx}, t)
+	}
+}
+
+func TestEventSetWaitList(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		size := int64(1024)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		src, err := ctx.CreateDeviceBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, src)
+
+		dst, err := ctx.CreateDeviceBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, dst)
+
+		var es EventSet
+		user, err := ctx.CreateUserEvent()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		es.Add(user)
+
+		// An event whose enqueue call failed must not end up in the wait list.
+		if err := cq.EnqueueCopyBuffer(src, dst, 0, 0, size+1, nil, es.New()); err == nil {
+			t.Error("expected an out of bounds copy to fail")
+		}
+
+		waitList := es.WaitList()
+		if len(waitList) != 1 || waitList[0] != user {
+			t.Error("unexpected wait list", waitList)
+		}
+		copied := es.New()
+		err = cq.EnqueueCopyBuffer(src, dst, 0, 0, size, waitList, copied)
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = cq.Flush()
+		if err != nil {
+			t.Error(err)
+		}
+		if ces, _, err := copied.Status(); ces == Complete || err != nil {
+			t.Error("copy completed before its wait list", ces, err)
+		}
+
+		err = user.SetComplete()
+		if err != nil {
+			t.Error(err)
+		}
+		if err := es.Wait(); err != nil {
+			t.Error(err)
+		}
+
+		err = es.Release()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll(toRelease, t)
+	}
+}
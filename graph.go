@@ -0,0 +1,255 @@
+package cl11
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Graph is a set of commands and host functions with dependencies between
+// them. Run enqueues the commands across command queues with the wait lists
+// the dependencies require.
+//
+// Nodes can only depend on nodes added before them, so a graph is acyclic by
+// construction. A graph can be run several times, but not concurrently.
+type Graph struct {
+	nodes []*GraphNode
+}
+
+// A node in a Graph.
+type GraphNode struct {
+
+	// The name used in errors.
+	Name string
+
+	// The mapping created by a node added with AddMapBuffer, set once the node
+	// has been enqueued.
+	MappedBuffer *MappedBuffer
+
+	graph   *Graph
+	index   int
+	deps    []*GraphNode
+	enqueue func(cq *CommandQueue, waitList []*Event, e *Event) error
+	host    func() error
+}
+
+// GraphError is returned by Graph.Run and identifies the node that failed.
+// Nodes that failed only because a dependency failed are not reported.
+type GraphError struct {
+	Node *GraphNode
+	Err  error
+}
+
+func (ge *GraphError) Error() string {
+	return fmt.Sprintf("cl: graph node %q: %v", ge.Node.Name, ge.Err)
+}
+
+func (ge *GraphError) Unwrap() error {
+	return ge.Err
+}
+
+// Creates an empty graph.
+func NewGraph() *Graph {
+	return &Graph{}
+}
+
+// Adds a node that enqueues a command with enqueue, which must use the queue,
+// wait list and event it is passed.
+func (g *Graph) Add(name string, enqueue func(cq *CommandQueue, waitList []*Event, e *Event) error,
+	deps ...*GraphNode) *GraphNode {
+	return g.add(&GraphNode{Name: name, enqueue: enqueue}, deps)
+}
+
+// Adds a node that runs fn on the host once its dependencies have completed.
+// Nodes depending on it wait for fn to return without blocking the enqueueing
+// of other nodes.
+func (g *Graph) AddHost(name string, fn func() error, deps ...*GraphNode) *GraphNode {
+	return g.add(&GraphNode{Name: name, host: fn}, deps)
+}
+
+// Adds a node that executes the kernel, see EnqueueNDRangeKernel. The kernel's
+// arguments must not be changed while the graph is running.
+func (g *Graph) AddKernel(name string, k *Kernel, globalOffset, globalSize, localSize []int,
+	deps ...*GraphNode) *GraphNode {
+	return g.Add(name, func(cq *CommandQueue, waitList []*Event, e *Event) error {
+		return cq.EnqueueNDRangeKernel(k, globalOffset, globalSize, localSize, waitList, e)
+	}, deps...)
+}
+
+// Adds a node that copies between buffers, see EnqueueCopyBuffer.
+func (g *Graph) AddCopyBuffer(name string, src, dst *Buffer, srcOffset, dstOffset, size int64,
+	deps ...*GraphNode) *GraphNode {
+	return g.Add(name, func(cq *CommandQueue, waitList []*Event, e *Event) error {
+		return cq.EnqueueCopyBuffer(src, dst, srcOffset, dstOffset, size, waitList, e)
+	}, deps...)
+}
+
+// Adds a node that maps a buffer without blocking, see EnqueueMapBuffer. The
+// mapping is available in the node's MappedBuffer to nodes depending on it.
+func (g *Graph) AddMapBuffer(name string, b *Buffer, flags MapFlags, offset, size int64,
+	deps ...*GraphNode) *GraphNode {
+	node := &GraphNode{Name: name}
+	node.enqueue = func(cq *CommandQueue, waitList []*Event, e *Event) error {
+		mb, err := cq.EnqueueMapBuffer(b, NonBlocking, flags, offset, size, waitList, e)
+		node.MappedBuffer = mb
+		return err
+	}
+	return g.add(node, deps)
+}
+
+// Adds a node that unmaps the buffer mapped by a node added with AddMapBuffer,
+// which it implicitly depends on.
+func (g *Graph) AddUnmapBuffer(name string, mapNode *GraphNode, deps ...*GraphNode) *GraphNode {
+	return g.Add(name, func(cq *CommandQueue, waitList []*Event, e *Event) error {
+		return cq.EnqueueUnmapBuffer(mapNode.MappedBuffer, waitList, e)
+	}, append([]*GraphNode{mapNode}, deps...)...)
+}
+
+func (g *Graph) add(node *GraphNode, deps []*GraphNode) *GraphNode {
+	node.graph = g
+	node.index = len(g.nodes)
+	node.deps = deps
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+// The state of a node during a run.
+type graphNodeRun struct {
+	queue    *CommandQueue
+	event    *Event
+	enqueued bool
+	err      error
+}
+
+// Enqueues the graph's commands on the queues and waits for the graph to
+// complete.
+//
+// Commands are distributed over the queues, which must share a context,
+// preferring the queue of a command's first dependency. Every dependency is
+// expressed in a wait list, so out-of-order queues may be used. Host functions
+// run in their own goroutine. All events created are released before Run
+// returns. If a node fails the remaining nodes are not enqueued and the first
+// failure is returned as a *GraphError.
+func (g *Graph) Run(queues ...*CommandQueue) error {
+
+	if len(queues) == 0 {
+		return fmt.Errorf("cl: Graph.Run: no command queues")
+	}
+
+	runs := make([]graphNodeRun, len(g.nodes))
+	var hosts sync.WaitGroup
+	next := 0
+
+	// Nodes are added after their dependencies so insertion order is a
+	// topological order.
+	for i, node := range g.nodes {
+
+		run := &runs[i]
+
+		waitList := make([]*Event, 0, len(node.deps))
+		for _, dep := range node.deps {
+			if dep.graph != g {
+				run.err = ErrGraphDependency
+				break
+			}
+			if depRun := &runs[dep.index]; depRun.event != nil {
+				waitList = append(waitList, depRun.event)
+				if run.queue == nil {
+					run.queue = depRun.queue
+				}
+			}
+		}
+		if run.err != nil {
+			break
+		}
+		if run.queue == nil {
+			run.queue = queues[next%len(queues)]
+			next++
+		}
+
+		// The goroutine started for a host node owns its err.
+		var err error
+		run.event = &Event{}
+		if node.host != nil {
+			err = g.startHost(node, run, waitList, &hosts)
+		} else {
+			err = node.enqueue(run.queue, waitList, run.event)
+		}
+		if err != nil {
+			run.err = err
+			run.event = nil
+			break
+		}
+		run.enqueued = true
+	}
+
+	// Wait for everything that was enqueued.
+	for _, cq := range queues {
+		cq.Flush()
+	}
+	for i := range runs {
+		if runs[i].event != nil {
+			runs[i].event.Wait()
+		}
+	}
+	hosts.Wait()
+
+	var err error
+	for i, node := range g.nodes {
+		run := &runs[i]
+		if run.enqueued && node.host == nil {
+			_, run.err, _ = run.event.Status()
+		}
+		if run.event != nil {
+			run.event.Release()
+		}
+		if run.err == nil || err != nil {
+			continue
+		}
+
+		// Report the node that failed, not those that failed because of it.
+		propagated := false
+		for _, dep := range node.deps {
+			if dep.graph == g && runs[dep.index].err != nil {
+				propagated = true
+			}
+		}
+		if !propagated {
+			err = &GraphError{Node: node, Err: run.err}
+		}
+	}
+
+	return err
+}
+
+// Creates a user event for a host node and starts a goroutine that runs the
+// node's function once the wait list has completed.
+func (g *Graph) startHost(node *GraphNode, run *graphNodeRun, waitList []*Event, hosts *sync.WaitGroup) error {
+
+	event, err := run.queue.Context.CreateUserEvent()
+	if err != nil {
+		return err
+	}
+	run.event = event
+
+	hosts.Add(1)
+	go func() {
+		defer hosts.Done()
+
+		var err error
+		if len(waitList) > 0 {
+			err = WaitForEvents(waitList...)
+		}
+		if err == nil {
+			err = node.host()
+			if err == nil {
+				event.SetComplete()
+				return
+			}
+		}
+
+		run.err = err
+		event.SetError(execStatusErrorForEventsInWaitListCode)
+	}()
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package cl11
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+func TestExecStatusErrorForEventsInWaitListCode(t *testing.T) {
+	if err := clw.CodeToError(execStatusErrorForEventsInWaitListCode); err != ExecStatusErrorForEventsInWaitList {
+		t.Error("code maps to", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 1024
+		size := int64(elements * 4)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq0, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq0)
+
+		// Out-of-order execution is optional, fall back to in-order.
+		cq1, err := ctx.CreateCommandQueue(device, QueueOutOfOrderExecution)
+		if err != nil {
+			cq1, err = ctx.CreateCommandQueue(device, 0)
+		}
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq1)
+
+		src, err := ctx.CreateDeviceBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, src)
+
+		dst, err := ctx.CreateHostBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, dst)
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		err = kernel.SetArguments(src)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		g := NewGraph()
+		index := g.AddKernel("index", kernel, nil, []int{elements}, nil)
+		copied := g.AddCopyBuffer("copy", src, dst, 0, 0, size, index)
+		mapped := g.AddMapBuffer("map", dst, MapRead, 0, size, copied)
+		check := g.AddHost("check", func() error {
+			b := mapped.MappedBuffer.Bytes()
+			for i := 0; i < elements; i++ {
+				if v := binary.LittleEndian.Uint32(b[i*4:]); v != uint32(i) {
+					return fmt.Errorf("element %d is %d", i, v)
+				}
+			}
+			return nil
+		}, mapped)
+		g.AddUnmapBuffer("unmap", mapped, check)
+
+		err = g.Run(cq0, cq1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// Only the failing node is reported, not the nodes depending on it.
+		failure := errors.New("failure")
+		g = NewGraph()
+		failed := g.AddHost("failed", func() error { return failure })
+		ran := false
+		g.AddHost("dependent", func() error { ran = true; return nil }, failed)
+		err = g.Run(cq0)
+		var graphErr *GraphError
+		if !errors.As(err, &graphErr) || graphErr.Node != failed || !errors.Is(err, failure) {
+			t.Error("expected failure of the first node, got", err)
+		}
+		if ran {
+			t.Error("dependent of a failed node ran")
+		}
+
+		other := NewGraph().AddHost("other", func() error { return nil })
+		g = NewGraph()
+		g.AddHost("foreign", func() error { return nil }, other)
+		err = g.Run(cq0)
+		if !errors.Is(err, ErrGraphDependency) {
+			t.Error("expected ErrGraphDependency, got", err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
@@ -319,6 +319,19 @@ func (b *Image) ReferenceCount() (int, error) {
 // Enqueues a command to copy image objects.
 func (cq *CommandQueue) EnqueueCopyImage(src, dst *Image, r *Rect, waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandCopyImage, Images: []*Image{src, dst}, Rect: r,
+			WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		src, dst, r = info.Images[0], info.Images[1], info.Rect
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -330,7 +343,8 @@ func (cq *CommandQueue) EnqueueCopyImage(src, dst *Image, r *Rect, waitList []*E
 	events := cq.createEvents(waitList)
 	err := clw.EnqueueCopyImage(cq.id, src.id, dst.id, r.Src.origin(), r.Dst.origin(), r.region(), events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", int64(r.size())*int64(src.ElementSize))
+	return cq.interceptAfter(info, err)
 }
 
 // Enqueues a command to map a region of an image object into the host address
@@ -338,6 +352,19 @@ func (cq *CommandQueue) EnqueueCopyImage(src, dst *Image, r *Rect, waitList []*E
 func (cq *CommandQueue) EnqueueMapImage(i *Image, bc BlockingCall, flags MapFlags, r *Rect, waitList []*Event,
 	e *Event) (*MappedImage, error) {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandMapImage, Images: []*Image{i}, Rect: r, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return nil, cq.interceptAfter(info, err)
+		}
+		i, r = info.Images[0], info.Rect
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+	e, own := cq.mapEvent(info, e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -361,6 +388,8 @@ func (cq *CommandQueue) EnqueueMapImage(i *Image, bc BlockingCall, flags MapFlag
 	pointer, err := clw.EnqueueMapImage(cq.id, i.id, clw.Bool(bc), clw.MapFlags(flags), r.Src.origin(), r.region(),
 		&rowPitch, &slicePitch, events, event)
 	cq.releaseEvents(events)
+	cq.profileCommand(e, internal, err, "", int64(r.size())*int64(i.ElementSize))
+	err = cq.interceptMapAfter(info, err, i.id, pointer, e, own)
 	if err != nil {
 		return nil, err
 	}
@@ -371,6 +400,18 @@ func (cq *CommandQueue) EnqueueMapImage(i *Image, bc BlockingCall, flags MapFlag
 // Enqueues a command to unmap a previously mapped image object.
 func (cq *CommandQueue) EnqueueUnmapImage(mi *MappedImage, waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandUnmapMemoryObject, Images: []*Image{mi.Image},
+			WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -382,5 +423,6 @@ func (cq *CommandQueue) EnqueueUnmapImage(mi *MappedImage, waitList []*Event, e
 	events := cq.createEvents(waitList)
 	err := clw.EnqueueUnmapMemObject(cq.id, mi.Image.id, mi.pointer, events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", 0)
+	return cq.interceptAfter(info, err)
 }
@@ -0,0 +1,229 @@
+package cl11
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rdwilliamson/cl11/internal/signature"
+)
+
+// Creates a program from an OpenCL C source file in a file system, such as an
+// embed.FS, expanding #include directives in Go rather than relying on the
+// driver resolving them relative to the working directory.
+//
+// Quoted includes are searched for relative to the including file and then in
+// opts.IncludeDirs, angle bracket includes only in opts.IncludeDirs. All paths
+// are fs.FS paths and opts may be nil. Files with include guards or #pragma
+// once are included once, and an include cycle is an error. #line directives
+// are inserted so compiler diagnostics refer to the original files and lines.
+// Since include directives are expanded regardless of any surrounding
+// conditionals, every included file must exist.
+func (c *Context) CreateProgramFromFS(fsys fs.FS, entry string, opts *BuildOptions) (*Program, error) {
+
+	source, _, err := expandIncludes(fsys, entry, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.CreateProgramWithSource(source)
+}
+
+var (
+	includeDirective = regexp.MustCompile(`^\s*#\s*include\s*([<"])([^>"]+)[>"]`)
+	pragmaOnce       = regexp.MustCompile(`^\s*#\s*pragma\s+once\b`)
+	ifndefDirective  = regexp.MustCompile(`^\s*#\s*(?:ifndef\s+(\w+)|if\s+!\s*defined\s*\(?\s*(\w+)\s*\)?)\s*$`)
+	defineDirective  = regexp.MustCompile(`^\s*#\s*define\s+(\w+)`)
+	endifDirective   = regexp.MustCompile(`^\s*#\s*endif\b`)
+)
+
+type includeExpander struct {
+	fsys  fs.FS
+	dirs  []string
+	out   bytes.Buffer
+	stack []string
+
+	// Files that are included at most once and the guard macros defined by
+	// included files.
+	once   map[string]bool
+	guards map[string]bool
+
+	// Every file read, in the order first read.
+	files []string
+}
+
+// Returns the entry file with all includes expanded and the files that were
+// read.
+func expandIncludes(fsys fs.FS, entry string, opts *BuildOptions) ([]byte, []string, error) {
+
+	e := &includeExpander{
+		fsys:   fsys,
+		once:   make(map[string]bool),
+		guards: make(map[string]bool),
+	}
+	if opts != nil {
+		e.dirs = opts.IncludeDirs
+	}
+
+	err := e.expand(path.Clean(entry))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.out.Bytes(), e.files, nil
+}
+
+func (e *includeExpander) expand(name string) error {
+
+	if e.once[name] {
+		return nil
+	}
+	source, err := fs.ReadFile(e.fsys, name)
+	if err != nil {
+		return err
+	}
+	e.files = appendUnique(e.files, name)
+
+	// Directives are found in the source without comments, which has the same
+	// lines, so commented out directives are ignored.
+	lines := strings.Split(string(source), "\n")
+	stripped := strings.Split(string(signature.StripComments(source)), "\n")
+
+	// The guard macro is defined at the top of the file, so it prevents the
+	// file including itself too.
+	guard := includeGuard(stripped)
+	if guard != "" {
+		if e.guards[guard] {
+			return nil
+		}
+		e.guards[guard] = true
+	}
+
+	for _, included := range e.stack {
+		if included == name {
+			return fmt.Errorf("cl: include cycle: %s -> %s", strings.Join(e.stack, " -> "), name)
+		}
+	}
+
+	e.stack = append(e.stack, name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	fmt.Fprintf(&e.out, "#line 1 %s\n", strconv.Quote(name))
+	for i, line := range lines {
+		directive := stripped[i]
+
+		if pragmaOnce.MatchString(directive) {
+			e.once[name] = true
+			e.out.WriteString("\n")
+			continue
+		}
+
+		m := includeDirective.FindStringSubmatch(directive)
+		if m == nil {
+			e.out.WriteString(line)
+			if i < len(lines)-1 {
+				e.out.WriteString("\n")
+			}
+			continue
+		}
+
+		included, err := e.resolve(name, m[2], m[1] == `"`)
+		if err != nil {
+			return fmt.Errorf("cl: %s:%d: %s", name, i+1, err)
+		}
+		err = e.expand(included)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&e.out, "\n#line %d %s\n", i+2, strconv.Quote(name))
+	}
+
+	return nil
+}
+
+// Finds an included file.
+func (e *includeExpander) resolve(includer, name string, quoted bool) (string, error) {
+
+	var candidates []string
+	if quoted {
+		candidates = append(candidates, path.Join(path.Dir(includer), name))
+	}
+	for _, dir := range e.dirs {
+		candidates = append(candidates, path.Join(dir, name))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := fs.Stat(e.fsys, candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("include file %s not found", name)
+}
+
+// Returns the include guard macro if the whole file is wrapped in
+// #ifndef X / #define X ... #endif.
+func includeGuard(lines []string) string {
+
+	// The guard must be the first and last thing in the file, so the first and
+	// last non-empty lines are checked first.
+	var first, last string
+	var directives []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if first == "" {
+			first = trimmed
+		}
+		last = trimmed
+		if strings.HasPrefix(trimmed, "#") {
+			directives = append(directives, trimmed)
+		}
+	}
+	if len(directives) < 3 || first != directives[0] || last != directives[len(directives)-1] ||
+		!endifDirective.MatchString(last) {
+		return ""
+	}
+
+	m := ifndefDirective.FindStringSubmatch(directives[0])
+	if m == nil {
+		return ""
+	}
+	guard := m[1] + m[2]
+
+	d := defineDirective.FindStringSubmatch(directives[1])
+	if d == nil || d[1] != guard {
+		return ""
+	}
+
+	// The closing #endif must belong to the opening #ifndef.
+	depth := 0
+	for i, directive := range directives {
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(directive, "#")), "if"):
+			depth++
+		case endifDirective.MatchString(directive):
+			depth--
+			if depth == 0 && i != len(directives)-1 {
+				return ""
+			}
+		}
+	}
+
+	return guard
+}
+
+func appendUnique(s []string, value string) []string {
+	for _, v := range s {
+		if v == value {
+			return s
+		}
+	}
+	return append(s, value)
+}
@@ -0,0 +1,130 @@
+package cl11
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"kernels/main.cl": {Data: []byte(
+			"#include \"common.h\"\n" +
+				"// #include \"missing.h\"\n" +
+				"#include <math.h>\n" +
+				"#include \"common.h\"\n" +
+				"__kernel void k(__global float* a) {}\n")},
+		"kernels/common.h": {Data: []byte(
+			"#ifndef COMMON_H\n" +
+				"#define COMMON_H\n" +
+				"#include \"once.h\"\n" +
+				"#define TILE 16\n" +
+				"#endif\n")},
+		"kernels/once.h": {Data: []byte("#pragma once\n#include \"common.h\"\ntypedef float real;\n")},
+		"include/math.h": {Data: []byte("#define SQUARE(x) ((x)*(x))\n")},
+	}
+
+	source, files, err := expandIncludes(fsys, "kernels/main.cl", &BuildOptions{IncludeDirs: []string{"include"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `#line 1 "kernels/main.cl"
+#line 1 "kernels/common.h"
+#ifndef COMMON_H
+#define COMMON_H
+#line 1 "kernels/once.h"
+
+
+#line 3 "kernels/once.h"
+typedef float real;
+`
+	if !strings.HasPrefix(string(source), want) {
+		t.Errorf("got\n%s", source)
+	}
+
+	for _, s := range []string{
+		"typedef float real;\n\n#line 4 \"kernels/common.h\"\n#define TILE 16",
+		"// #include \"missing.h\"\n#line 1 \"include/math.h\"\n#define SQUARE(x) ((x)*(x))\n\n#line 4 \"kernels/main.cl\"",
+		"\n#line 5 \"kernels/main.cl\"\n__kernel void k(__global float* a) {}\n",
+	} {
+		if !strings.Contains(string(source), s) {
+			t.Errorf("expanded source does not contain %q\n%s", s, source)
+		}
+	}
+	if strings.Count(string(source), "typedef float real;") != 1 {
+		t.Errorf("once.h included more than once\n%s", source)
+	}
+
+	wantFiles := []string{"kernels/main.cl", "kernels/common.h", "kernels/once.h", "include/math.h"}
+	if strings.Join(files, ",") != strings.Join(wantFiles, ",") {
+		t.Error("got files", files, "want", wantFiles)
+	}
+}
+
+func TestExpandIncludesErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.cl":    {Data: []byte("#include \"b.h\"\n")},
+		"b.h":     {Data: []byte("#include \"a.cl\"\n")},
+		"c.cl":    {Data: []byte("\n#include \"missing.h\"\n")},
+		"guard.h": {Data: []byte("#ifndef G\n#define G\n#endif\n#include \"guard.h\"\n")},
+	}
+
+	_, _, err := expandIncludes(fsys, "a.cl", nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Error("expected include cycle error, got", err)
+	}
+
+	_, _, err = expandIncludes(fsys, "c.cl", nil)
+	if err == nil || !strings.Contains(err.Error(), "c.cl:2") {
+		t.Error("expected missing include error, got", err)
+	}
+
+	// Not a guard since the include is outside the #ifndef.
+	_, _, err = expandIncludes(fsys, "guard.h", nil)
+	if err == nil {
+		t.Error("expected include cycle error for file without a complete guard")
+	}
+}
+
+func TestIncludeGuardWithCode(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.cl": {Data: []byte("#include \"clamp.h\"\n#include \"clamp.h\"\n")},
+		"clamp.h": {Data: []byte(
+			"#ifndef CLAMP_H\n" +
+				"#define CLAMP_H\n" +
+				"#include \"clamp.h\"\n" +
+				"int clamp_index(int i, int n) {\n" +
+				"\tif (i < 0)\n" +
+				"\t\treturn 0;\n" +
+				"\tint iffy = i < n ? i : n - 1;\n" +
+				"\treturn iffy;\n" +
+				"}\n" +
+				"#endif\n")},
+	}
+
+	source, _, err := expandIncludes(fsys, "main.cl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(source), "int clamp_index") != 1 {
+		t.Errorf("clamp.h included more than once\n%s", source)
+	}
+
+	lines := strings.Split(string(fsys["clamp.h"].Data), "\n")
+	if guard := includeGuard(lines); guard != "CLAMP_H" {
+		t.Errorf("got guard %q, want CLAMP_H", guard)
+	}
+	code := append([]string{"int x;"}, lines...)
+	if guard := includeGuard(code); guard != "" {
+		t.Errorf("got guard %q for code outside the guard", guard)
+	}
+
+	// The if statement must not be mistaken for the opening of the #endif
+	// that closes the guard.
+	unguarded := strings.Split("#ifndef G\n#define G\nint f(int x) {\n\tif (x)\n\t\treturn 1;\n\treturn 0;\n}\n"+
+		"#endif\nint g;\n#ifdef Y\n#endif\n", "\n")
+	if guard := includeGuard(unguarded); guard != "" {
+		t.Errorf("got guard %q for code after the guard", guard)
+	}
+}
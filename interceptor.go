@@ -0,0 +1,128 @@
+package cl11
+
+import (
+	"unsafe"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// An Interceptor adds behaviour, such as logging, metrics, validation or fault
+// injection, around every Enqueue method of the command queues it is added to.
+type Interceptor interface {
+
+	// Called before the command is enqueued. The info may be modified to
+	// change the call, e.g. to add to the wait list or replace the event.
+	// Returning an error vetoes the call: the command is not enqueued, the
+	// remaining interceptors' Before methods are not called and the Enqueue
+	// method returns the error.
+	Before(cq *CommandQueue, info *EnqueueInfo) error
+
+	// Called after the command has been enqueued, or the call vetoed, with the
+	// error the call resulted in. The returned error replaces it.
+	After(cq *CommandQueue, info *EnqueueInfo, err error) error
+}
+
+// Describes an Enqueue call to interceptors. Fields that do not apply to the
+// command are zero. Changes an interceptor makes to a field are used for the
+// call, except for CommandType, Kernel and the Buffers and Images of unmap
+// commands, which are read-only. Slices may be replaced but must keep their
+// length.
+type EnqueueInfo struct {
+
+	// The command type. Zero for EnqueueBarrier and EnqueueWaitForEvents.
+	CommandType CommandType
+
+	// The kernel of kernel commands.
+	Kernel *Kernel
+
+	// The buffers and images the command uses, sources before destinations.
+	Buffers []*Buffer
+	Images  []*Image
+
+	// The byte offset into each buffer of buffer commands and the number of
+	// bytes copied or mapped.
+	Offsets []int64
+	Size    int64
+
+	// The rectangle of rectangle and image commands.
+	Rect *Rect
+
+	// The work sizes of ND range kernel commands.
+	GlobalOffset, GlobalSize, LocalSize []int
+
+	// The wait list and event passed to the Enqueue method. After the command
+	// has been enqueued the event refers to it.
+	WaitList []*Event
+	Event    *Event
+
+	// The interceptors whose Before method was called.
+	called []Interceptor
+}
+
+// Adds interceptors to the queue. Their Before methods are called in the order
+// they were added and their After methods in the reverse order.
+func (cq *CommandQueue) AddInterceptor(interceptors ...Interceptor) {
+	cq.Interceptors = append(cq.Interceptors, interceptors...)
+}
+
+// Calls the interceptors' Before methods. If it returns an error the command
+// must not be enqueued. In either case after must be called.
+func (cq *CommandQueue) interceptBefore(info *EnqueueInfo) error {
+	for i, interceptor := range cq.Interceptors {
+		info.called = cq.Interceptors[:i+1]
+		err := interceptor.Before(cq, info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls the After methods of the interceptors whose Before method was called
+// and returns the resulting error. The info is nil if the queue had no
+// interceptors.
+func (cq *CommandQueue) interceptAfter(info *EnqueueInfo, err error) error {
+	if info == nil {
+		return err
+	}
+	for i := len(info.called) - 1; i >= 0; i-- {
+		err = info.called[i].After(cq, info, err)
+	}
+	return err
+}
+
+// Returns the event of a map command if the queue has interceptors and the
+// caller did not give one, so a map an interceptor turns into an error can
+// still be unmapped after it.
+func (cq *CommandQueue) mapEvent(info *EnqueueInfo, e *Event) (*Event, bool) {
+	if info != nil && e == nil {
+		return &Event{}, true
+	}
+	return e, false
+}
+
+// Calls the interceptors' After methods for a map command. If the map
+// succeeded but an interceptor returned an error the memory is unmapped,
+// after the map, since the caller never sees the mapping. The event is
+// released if it was created by mapEvent.
+func (cq *CommandQueue) interceptMapAfter(info *EnqueueInfo, err error, mem clw.Mem, pointer unsafe.Pointer,
+	e *Event, own bool) error {
+
+	if info == nil {
+		return err
+	}
+
+	mapErr := err
+	err = cq.interceptAfter(info, err)
+	if mapErr != nil {
+		return err
+	}
+
+	if err != nil {
+		clw.EnqueueUnmapMemObject(cq.id, mem, pointer, []clw.Event{e.id}, nil)
+	}
+	if own {
+		e.Release()
+	}
+	return err
+}
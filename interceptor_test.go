@@ -0,0 +1,212 @@
+package cl11
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type recordingInterceptor struct {
+	name  string
+	calls *[]string
+	veto  error
+	infos []EnqueueInfo
+}
+
+func (ri *recordingInterceptor) Before(cq *CommandQueue, info *EnqueueInfo) error {
+	*ri.calls = append(*ri.calls, ri.name+" before")
+	ri.infos = append(ri.infos, *info)
+	return ri.veto
+}
+
+func (ri *recordingInterceptor) After(cq *CommandQueue, info *EnqueueInfo, err error) error {
+	*ri.calls = append(*ri.calls, ri.name+" after")
+	return err
+}
+
+func TestInterceptorVeto(t *testing.T) {
+	var calls []string
+	veto := errors.New("veto")
+	outer := &recordingInterceptor{name: "outer", calls: &calls}
+	inner := &recordingInterceptor{name: "inner", calls: &calls, veto: veto}
+	last := &recordingInterceptor{name: "last", calls: &calls}
+
+	cq := &CommandQueue{}
+	cq.AddInterceptor(outer, inner, last)
+
+	src, dst := &Buffer{}, &Buffer{}
+	err := cq.EnqueueCopyBuffer(src, dst, 8, 16, 32, nil, nil)
+	if err != veto {
+		t.Error("expected veto, got", err)
+	}
+
+	want := []string{"outer before", "inner before", "inner after", "outer after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+
+	info := outer.infos[0]
+	if info.CommandType != CommandCopyBuffer || info.Buffers[0] != src || info.Buffers[1] != dst ||
+		!reflect.DeepEqual(info.Offsets, []int64{8, 16}) || info.Size != 32 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+type waitInterceptor struct {
+	wait     *Event
+	enqueued []*Event
+}
+
+func (wi *waitInterceptor) Before(cq *CommandQueue, info *EnqueueInfo) error {
+	info.WaitList = append(info.WaitList, wi.wait)
+	if info.Event == nil {
+		info.Event = &Event{}
+	}
+	return nil
+}
+
+func (wi *waitInterceptor) After(cq *CommandQueue, info *EnqueueInfo, err error) error {
+	if err == nil {
+		wi.enqueued = append(wi.enqueued, info.Event)
+	}
+	return err
+}
+
+func TestInterceptorModify(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		src, err := ctx.CreateDeviceBuffer(64, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, src)
+
+		dst, err := ctx.CreateDeviceBuffer(64, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, dst)
+
+		gate, err := ctx.CreateUserEvent()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, gate)
+
+		wi := &waitInterceptor{wait: gate}
+		cq.AddInterceptor(wi)
+
+		err = cq.EnqueueCopyBuffer(src, dst, 0, 0, 64, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(wi.enqueued) != 1 {
+			t.Fatal("interceptor did not see the command")
+		}
+		copied := wi.enqueued[0]
+		toRelease = append(toRelease, copied)
+
+		cq.Flush()
+		if status, _, err := copied.Status(); err != nil || status == Complete {
+			t.Error("copy did not wait for the added event", status, err)
+		}
+
+		err = gate.SetComplete()
+		if err != nil {
+			t.Error(err)
+		}
+		err = copied.Wait()
+		if err != nil {
+			t.Error(err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+type rejectInterceptor struct {
+	err error
+}
+
+func (ri *rejectInterceptor) Before(cq *CommandQueue, info *EnqueueInfo) error {
+	return nil
+}
+
+func (ri *rejectInterceptor) After(cq *CommandQueue, info *EnqueueInfo, err error) error {
+	if err == nil {
+		return ri.err
+	}
+	return err
+}
+
+func TestInterceptorRejectMap(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		buffer, err := ctx.CreateHostBuffer(64, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, buffer)
+
+		reject := errors.New("reject")
+		cq.AddInterceptor(&rejectInterceptor{err: reject})
+
+		// The rejected mapping is unmapped by the queue, after the map.
+		mb, err := cq.EnqueueMapBuffer(buffer, NonBlocking, MapRead, 0, 64, nil, nil)
+		if mb != nil || err != reject {
+			t.Error("expected the map to be rejected, got", mb, err)
+		}
+		err = cq.Finish()
+		if err != nil {
+			t.Error(err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
@@ -0,0 +1,376 @@
+// Package signature parses the kernel function signatures declared in OpenCL C
+// source. It has no dependency on the OpenCL library, so clgen can use it
+// without cgo.
+package signature
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The signature of a kernel function as declared in OpenCL C source.
+type KernelSignature struct {
+
+	// The kernel function name.
+	Name string
+
+	// The kernel arguments in declaration order.
+	Args []KernelArg
+}
+
+// A kernel argument as declared in OpenCL C source.
+type KernelArg struct {
+
+	// The argument name.
+	Name string
+
+	// The type without address space, access or type qualifiers and without
+	// the pointer, e.g. "float" for "__global const float* in".
+	Type string
+
+	// The address space of a pointer argument (or image), the zero value for
+	// arguments passed by value.
+	AddressSpace AddressSpace
+
+	// True if the argument is a pointer.
+	Pointer bool
+
+	// True if the argument is a const pointer (or a read only image).
+	Const bool
+}
+
+type AddressSpace int
+
+const (
+	zeroAddressSpace AddressSpace = iota
+	GlobalSpace
+	LocalSpace
+	ConstantSpace
+	PrivateSpace
+)
+
+func (as AddressSpace) String() string {
+	switch as {
+	case zeroAddressSpace:
+		return ""
+	case GlobalSpace:
+		return "global"
+	case LocalSpace:
+		return "local"
+	case ConstantSpace:
+		return "constant"
+	case PrivateSpace:
+		return "private"
+	}
+	panic("unreachable")
+}
+
+// True if the argument is an image object.
+func (ka *KernelArg) IsImage() bool {
+	return ka.Type == "image2d_t" || ka.Type == "image3d_t"
+}
+
+// True if the argument is a sampler object.
+func (ka *KernelArg) IsSampler() bool {
+	return ka.Type == "sampler_t"
+}
+
+// The Go type of an argument passed by value that has a Go equivalent, e.g.
+// float32 for float and [4]float32 for float4, or nil. A 3 component vector
+// has the size of a 4 component one, so float3 is also [4]float32.
+func (ka *KernelArg) GoType() reflect.Type {
+
+	if ka.Pointer {
+		return nil
+	}
+
+	scalar, width := VectorType(ka.Type)
+	switch width {
+	case 0:
+		return nil
+	case 1:
+		return ScalarTypes[scalar]
+	case 3:
+		width = 4
+	}
+	return reflect.ArrayOf(width, ScalarTypes[scalar])
+}
+
+// Parses the kernel function signatures declared in OpenCL C source.
+//
+// The source is not preprocessed, kernels declared through macros are not
+// found and types defined through typedef or #define are reported verbatim.
+func ParseKernelSignatures(source []byte) ([]KernelSignature, error) {
+
+	tokens := tokenize(StripComments(source))
+
+	var signatures []KernelSignature
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "__kernel" && tokens[i] != "kernel" {
+			continue
+		}
+
+		// Skip to the function name, the identifier directly before "(", while
+		// passing over any attributes.
+		j := i + 1
+		for ; j < len(tokens); j++ {
+			if tokens[j] == "__attribute__" {
+				end, err := matchParen(tokens, j+1)
+				if err != nil {
+					return nil, err
+				}
+				j = end
+				continue
+			}
+			if tokens[j] == "(" {
+				break
+			}
+		}
+		if j >= len(tokens) || j-1 <= i {
+			return nil, errors.New("cl: ParseKernelSignatures: malformed kernel declaration")
+		}
+		end, err := matchParen(tokens, j)
+		if err != nil {
+			return nil, err
+		}
+
+		signature := KernelSignature{Name: tokens[j-1]}
+		for _, arg := range splitArgs(tokens[j+1 : end]) {
+			if len(arg) == 1 && arg[0] == "void" {
+				continue
+			}
+			ka, err := parseKernelArg(arg)
+			if err != nil {
+				return nil, fmt.Errorf("cl: ParseKernelSignatures: kernel %s: %s", signature.Name, err)
+			}
+			signature.Args = append(signature.Args, ka)
+		}
+		signatures = append(signatures, signature)
+		i = end
+	}
+
+	return signatures, nil
+}
+
+func parseKernelArg(tokens []string) (KernelArg, error) {
+
+	var ka KernelArg
+	var typeTokens []string
+loop:
+	for _, token := range tokens {
+		switch token {
+		case "__global", "global":
+			ka.AddressSpace = GlobalSpace
+		case "__local", "local":
+			ka.AddressSpace = LocalSpace
+		case "__constant", "constant":
+			ka.AddressSpace = ConstantSpace
+			ka.Const = true
+		case "__private", "private":
+			ka.AddressSpace = PrivateSpace
+		case "__read_only", "read_only":
+			ka.Const = true
+		case "const":
+			// After the "*" it is a const pointer, not a pointer to const.
+			if !ka.Pointer {
+				ka.Const = true
+			}
+		case "__write_only", "write_only", "__read_write", "read_write", "volatile", "restrict", "__restrict":
+		case "*":
+			ka.Pointer = true
+		case "[":
+			// An array declaration, "float a[]", is a pointer.
+			ka.Pointer = true
+			break loop
+		default:
+			typeTokens = append(typeTokens, token)
+		}
+	}
+
+	if len(typeTokens) < 2 {
+		return ka, errors.New("missing argument name or type")
+	}
+	ka.Name = typeTokens[len(typeTokens)-1]
+	ka.Type = normalizeType(typeTokens[:len(typeTokens)-1])
+
+	switch {
+	case ka.IsImage():
+		if ka.AddressSpace == zeroAddressSpace {
+			ka.AddressSpace = GlobalSpace
+		}
+	case !ka.Pointer:
+		// Only pointers have a meaningful const, "const int n" is a value.
+		ka.Const = false
+	}
+
+	return ka, nil
+}
+
+// Maps the multiple keyword forms of unsigned types to their short names.
+func normalizeType(tokens []string) string {
+	joined := strings.Join(tokens, " ")
+	switch joined {
+	case "unsigned char":
+		return "uchar"
+	case "unsigned short":
+		return "ushort"
+	case "unsigned int", "unsigned":
+		return "uint"
+	case "unsigned long":
+		return "ulong"
+	}
+	return joined
+}
+
+// Splits the tokens between a kernel's parentheses into arguments.
+func splitArgs(tokens []string) [][]string {
+	var args [][]string
+	var depth, start int
+	for i, token := range tokens {
+		switch token {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case ",":
+			if depth == 0 {
+				args = append(args, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(tokens) {
+		args = append(args, tokens[start:])
+	}
+	return args
+}
+
+// Returns the index of the parenthesis matching the one at tokens[start].
+func matchParen(tokens []string, start int) (int, error) {
+	if start >= len(tokens) || tokens[start] != "(" {
+		return 0, errors.New("cl: ParseKernelSignatures: expected (")
+	}
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New("cl: ParseKernelSignatures: unbalanced parentheses")
+}
+
+// Splits source into identifiers, numbers and single character punctuation.
+// Preprocessor directives are skipped.
+func tokenize(source []byte) []string {
+	var tokens []string
+	for _, line := range bytes.Split(source, []byte("\n")) {
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '#' {
+			continue
+		}
+		runes := []rune(string(line))
+		for i := 0; i < len(runes); {
+			r := runes[i]
+			switch {
+			case unicode.IsSpace(r):
+				i++
+			case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+				j := i
+				for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+					j++
+				}
+				tokens = append(tokens, string(runes[i:j]))
+				i = j
+			default:
+				tokens = append(tokens, string(r))
+				i++
+			}
+		}
+	}
+	return tokens
+}
+
+// Replaces comments with spaces, keeping newlines so line numbers are
+// unchanged.
+func StripComments(source []byte) []byte {
+	result := make([]byte, 0, len(source))
+	for i := 0; i < len(source); i++ {
+		switch {
+		case source[i] == '"':
+			// Copy string literals verbatim.
+			j := i + 1
+			for j < len(source) && source[j] != '"' && source[j] != '\n' {
+				if source[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(source) {
+				j = len(source) - 1
+			}
+			result = append(result, source[i:j+1]...)
+			i = j
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '/':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			if i < len(source) {
+				result = append(result, '\n')
+			}
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '*':
+			i += 2
+			for i < len(source) && !(source[i] == '*' && i+1 < len(source) && source[i+1] == '/') {
+				if source[i] == '\n' {
+					result = append(result, '\n')
+				}
+				i++
+			}
+			i++
+			result = append(result, ' ')
+		default:
+			result = append(result, source[i])
+		}
+	}
+	return result
+}
+
+// The Go types matching the OpenCL C scalar types.
+var ScalarTypes = map[string]reflect.Type{
+	"char":   reflect.TypeOf(int8(0)),
+	"uchar":  reflect.TypeOf(uint8(0)),
+	"short":  reflect.TypeOf(int16(0)),
+	"ushort": reflect.TypeOf(uint16(0)),
+	"int":    reflect.TypeOf(int32(0)),
+	"uint":   reflect.TypeOf(uint32(0)),
+	"long":   reflect.TypeOf(int64(0)),
+	"ulong":  reflect.TypeOf(uint64(0)),
+	"float":  reflect.TypeOf(float32(0)),
+	"double": reflect.TypeOf(float64(0)),
+}
+
+// Splits a vector type such as "float4" into its scalar type and width. Width
+// is zero for scalar types and for unknown types.
+func VectorType(typeName string) (string, int) {
+	if _, ok := ScalarTypes[typeName]; ok {
+		return typeName, 1
+	}
+	for _, width := range []int{16, 8, 4, 3, 2} {
+		suffix := strconv.Itoa(width)
+		if strings.HasSuffix(typeName, suffix) {
+			if _, ok := ScalarTypes[strings.TrimSuffix(typeName, suffix)]; ok {
+				return strings.TrimSuffix(typeName, suffix), width
+			}
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,82 @@
+package signature
+
+import (
+	"reflect"
+	"testing"
+)
+
+var signatureSource = `
+#define TILE 16
+
+// A __kernel in a comment: __kernel void nope(int a)
+__kernel void saxpy(float a, __global const float* x, __global float *y)
+{
+	int i = get_global_id(0);
+	y[i] = a * x[i] + y[i];
+}
+
+/* kernel void nope2(void) */
+__kernel __attribute__((reqd_work_group_size(16, 16, 1)))
+void blur(read_only image2d_t src, __write_only image2d_t dst, sampler_t s,
+	__local float4* tile, unsigned int n, __constant int weights[])
+{
+}
+
+kernel void empty(void) {}
+`
+
+func TestParseKernelSignatures(t *testing.T) {
+	got, err := ParseKernelSignatures([]byte(signatureSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []KernelSignature{
+		{"saxpy", []KernelArg{
+			{Name: "a", Type: "float"},
+			{Name: "x", Type: "float", AddressSpace: GlobalSpace, Pointer: true, Const: true},
+			{Name: "y", Type: "float", AddressSpace: GlobalSpace, Pointer: true},
+		}},
+		{"blur", []KernelArg{
+			{Name: "src", Type: "image2d_t", AddressSpace: GlobalSpace, Const: true},
+			{Name: "dst", Type: "image2d_t", AddressSpace: GlobalSpace},
+			{Name: "s", Type: "sampler_t"},
+			{Name: "tile", Type: "float4", AddressSpace: LocalSpace, Pointer: true},
+			{Name: "n", Type: "uint"},
+			{Name: "weights", Type: "int", AddressSpace: ConstantSpace, Pointer: true, Const: true},
+		}},
+		{"empty", nil},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseKernelSignaturesMalformed(t *testing.T) {
+	_, err := ParseKernelSignatures([]byte("__kernel void broken(__global float* a"))
+	if err == nil {
+		t.Error("expected error for unbalanced parentheses")
+	}
+}
+
+func TestKernelArgGoType(t *testing.T) {
+	for _, test := range []struct {
+		arg  KernelArg
+		want string
+	}{
+		{KernelArg{Type: "float"}, "float32"},
+		{KernelArg{Type: "uchar16"}, "[16]uint8"},
+		{KernelArg{Type: "float3"}, "[4]float32"},
+		{KernelArg{Type: "float", Pointer: true, AddressSpace: GlobalSpace}, "<nil>"},
+		{KernelArg{Type: "image2d_t"}, "<nil>"},
+	} {
+		got := "<nil>"
+		if goType := test.arg.GoType(); goType != nil {
+			got = goType.String()
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.arg.Type, got, test.want)
+		}
+	}
+}
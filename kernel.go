@@ -26,6 +26,9 @@ type Kernel struct {
 
 	// Information about the kernel object that may be specific to a device.
 	WorkGroupInfo []KernelWorkGroupInfo
+
+	// The argument values last set, used to recreate the kernel.
+	args []interface{}
 }
 
 // Information about the kernel object specific to a device.
@@ -252,6 +255,7 @@ func (k *Kernel) SetArg(index int, arg interface{}) error {
 
 	var size uintptr
 	var pointer unsafe.Pointer
+	recorded := arg
 
 	switch v := arg.(type) {
 
@@ -263,6 +267,10 @@ func (k *Kernel) SetArg(index int, arg interface{}) error {
 		pointer = unsafe.Pointer(&v.id)
 		size = unsafe.Sizeof(v.id)
 
+	case *Sampler:
+		pointer = unsafe.Pointer(&v.id)
+		size = unsafe.Sizeof(v.id)
+
 	case LocalSpaceArg:
 		pointer = nil
 		size = uintptr(v)
@@ -277,8 +285,11 @@ func (k *Kernel) SetArg(index int, arg interface{}) error {
 			kind = value.Kind()
 		}
 
-		// Create an addressable copy if required.
-		if !value.CanAddr() {
+		// Create an addressable copy if required. An argument passed by pointer
+		// is recorded by value, what it points to may change.
+		if value.CanAddr() {
+			recorded = value.Interface()
+		} else {
 			newvalue := reflect.New(value.Type()).Elem()
 			newvalue.Set(value)
 			value = newvalue
@@ -288,7 +299,47 @@ func (k *Kernel) SetArg(index int, arg interface{}) error {
 		size = value.Type().Size()
 	}
 
-	return clw.SetKernelArg(k.id, clw.Uint(index), clw.Size(size), pointer)
+	err := clw.SetKernelArg(k.id, clw.Uint(index), clw.Size(size), pointer)
+	if err != nil {
+		return err
+	}
+
+	if index >= len(k.args) {
+		args := make([]interface{}, index+1)
+		copy(args, k.args)
+		k.args = args
+	}
+	k.args[index] = recorded
+
+	return nil
+}
+
+// Creates a new kernel object for the same function with the same argument
+// values.
+//
+// Since SetArg is not safe to call concurrently on the same kernel, goroutines
+// that need to launch the same kernel with different arguments should each use
+// their own clone (see KernelPool). Only arguments set through SetArg or
+// SetArguments are applied to the clone.
+func (k *Kernel) Clone() (*Kernel, error) {
+
+	clone, err := k.Program.CreateKernel(k.FunctionName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, arg := range k.args {
+		if arg == nil {
+			continue
+		}
+		err = clone.SetArg(i, arg)
+		if err != nil {
+			clone.Release()
+			return nil, err
+		}
+	}
+
+	return clone, nil
 }
 
 // Set all argument values of a kernel.
@@ -306,12 +357,28 @@ func (k *Kernel) SetArguments(args ...interface{}) error {
 
 // Enqueues a command to execute a kernel on a device.
 //
-// GlobalOffset is optional, if it omitted it is assumed to be all zeros. The
-// dimensions of globalOffset, globalSize, and localSize must match and be less
-// than or equal to the max work item dimensions.
+// GlobalOffset is optional, if it omitted it is assumed to be all zeros.
+// LocalSize is optional, if it is omitted the command queue's Autotuner result
+// is used, if there is one, otherwise the implementation will determine how to
+// break the global work-items into work-groups. The dimensions of
+// globalOffset, globalSize, and localSize must match and be less than or equal
+// to the max work item dimensions.
 func (cq *CommandQueue) EnqueueNDRangeKernel(k *Kernel, globalOffset, globalSize, localSize []int,
 	waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandNDRangeKernel, Kernel: k, GlobalOffset: globalOffset,
+			GlobalSize: globalSize, LocalSize: localSize, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		globalOffset, globalSize, localSize = info.GlobalOffset, info.GlobalSize, info.LocalSize
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -320,6 +387,10 @@ func (cq *CommandQueue) EnqueueNDRangeKernel(k *Kernel, globalOffset, globalSize
 		e.CommandQueue = cq
 	}
 
+	if localSize == nil && cq.Autotuner != nil {
+		localSize, _ = cq.Autotuner.LocalSize(cq.Device, k, globalSize)
+	}
+
 	dims := len(globalSize)
 	sizes := make([]clw.Size, dims*3)
 	if globalOffset != nil {
@@ -329,14 +400,21 @@ func (cq *CommandQueue) EnqueueNDRangeKernel(k *Kernel, globalOffset, globalSize
 	}
 	for i := 0; i < dims; i++ {
 		sizes[dims+i] = clw.Size(globalSize[i])
-		sizes[2*dims+i] = clw.Size(localSize[i])
+	}
+	local := sizes[2*dims:]
+	if localSize != nil {
+		for i := 0; i < dims; i++ {
+			local[i] = clw.Size(localSize[i])
+		}
+	} else {
+		local = nil
 	}
 
 	events := cq.createEvents(waitList)
-	err := clw.EnqueueNDRangeKernel(cq.id, k.id, sizes[:dims], sizes[dims:2*dims], sizes[2*dims:],
-		events, event)
+	err := clw.EnqueueNDRangeKernel(cq.id, k.id, sizes[:dims], sizes[dims:2*dims], local, events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, k.FunctionName, 0)
+	return cq.interceptAfter(info, err)
 }
 
 // Enqueues a command to execute a kernel on a device.
@@ -344,6 +422,17 @@ func (cq *CommandQueue) EnqueueNDRangeKernel(k *Kernel, globalOffset, globalSize
 // The kernel is executed using a single work-item.
 func (cq *CommandQueue) EnqueueTask(k *Kernel, waitList []*Event, e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandTask, Kernel: k, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -355,5 +444,177 @@ func (cq *CommandQueue) EnqueueTask(k *Kernel, waitList []*Event, e *Event) erro
 	events := cq.createEvents(waitList)
 	err := clw.EnqueueTask(cq.id, k.id, events, event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, k.FunctionName, 0)
+	return cq.interceptAfter(info, err)
+}
+
+// Enqueues a kernel execution split into several launches of at most maxItems
+// work-items each.
+//
+// Some devices limit the total number of work-items in a launch or abort long
+// running launches (e.g. a display watchdog). The range is split along the
+// highest dimension whose local size still fits within maxItems, each launch
+// covers a whole number of work-groups and uses globalOffset to place itself
+// in the full range, so get_global_id is unaffected. Each launch waits on the
+// previous one and the first waits on waitList. The event, if not nil, is the
+// event of the final launch and completes once all launches have completed.
+//
+// LocalSize is optional, if it is omitted the command queue's Autotuner result
+// for the full range is used for every launch, if there is one, otherwise the
+// work-group size is left to the implementation and a work-group is assumed to
+// be a single work-item when splitting.
+func (cq *CommandQueue) EnqueueNDRangeKernelChunked(k *Kernel, globalOffset, globalSize, localSize []int,
+	maxItems int, waitList []*Event, e *Event) error {
+
+	// The launches must not look up a work-group size for their own range,
+	// which may not divide it.
+	if localSize == nil && cq.Autotuner != nil {
+		localSize, _ = cq.Autotuner.LocalSize(cq.Device, k, globalSize)
+	}
+
+	chunks, err := ndRangeChunks(globalOffset, globalSize, localSize, maxItems)
+	if err != nil {
+		return err
+	}
+
+	var previous *Event
+	for i, chunk := range chunks {
+
+		// Intermediate launches always need an event to chain the next launch
+		// to, the final launch uses the caller's event.
+		event := e
+		if i < len(chunks)-1 {
+			event = &Event{}
+		}
+
+		chunkWaitList := waitList
+		if previous != nil {
+			chunkWaitList = []*Event{previous}
+		}
+
+		err = cq.EnqueueNDRangeKernel(k, chunk.offset, chunk.size, localSize, chunkWaitList, event)
+		if previous != nil {
+			// The enqueued command holds its own reference to the event.
+			if releaseErr := previous.Release(); err == nil {
+				err = releaseErr
+			}
+		}
+		if err != nil {
+			if event != nil && event != e {
+				event.Release()
+			}
+			return err
+		}
+
+		previous = event
+	}
+
+	return nil
+}
+
+// A single launch of a chunked ND range.
+type ndRangeChunk struct {
+	offset []int
+	size   []int
+}
+
+// Returns the number of work-items in a work-group of the dimensions above
+// dimension i.
+func upperLocal(local []int, i int) int {
+	items := 1
+	for _, l := range local[i+1:] {
+		items *= l
+	}
+	return items
+}
+
+// Splits an ND range into launches of at most maxItems work-items that each
+// contain a whole number of work-groups.
+func ndRangeChunks(globalOffset, globalSize, localSize []int, maxItems int) ([]ndRangeChunk, error) {
+
+	dims := len(globalSize)
+	if dims == 0 || maxItems < 1 {
+		return nil, ErrInvalidChunkSize
+	}
+
+	offset := make([]int, dims)
+	if globalOffset != nil {
+		copy(offset, globalOffset)
+	}
+
+	local := make([]int, dims)
+	for i := range local {
+		local[i] = 1
+		if localSize != nil && localSize[i] > 0 {
+			local[i] = localSize[i]
+		}
+	}
+
+	// Find the highest dimension to split in which a single work-group (times
+	// the full extent of the lower dimensions) fits in a launch. The dimensions
+	// above it are launched one work-group at a time, so their local sizes
+	// count towards a launch too.
+	split := -1
+	lower := 1
+	for i := 0; i < dims; i++ {
+		if lower*local[i]*upperLocal(local, i) > maxItems {
+			break
+		}
+		split = i
+		lower *= globalSize[i]
+	}
+	if split < 0 {
+		return nil, ErrInvalidChunkSize
+	}
+
+	lower = 1
+	for i := 0; i < split; i++ {
+		lower *= globalSize[i]
+	}
+	step := maxItems / (lower * upperLocal(local, split))
+	step -= step % local[split]
+	if step > globalSize[split] {
+		step = globalSize[split]
+	}
+
+	// Iterate over the chunks like an odometer, dimension split is the fastest
+	// moving digit.
+	var chunks []ndRangeChunk
+	position := make([]int, dims)
+	for {
+		chunk := ndRangeChunk{offset: make([]int, dims), size: make([]int, dims)}
+		for i := 0; i < dims; i++ {
+			switch {
+			case i < split:
+				chunk.size[i] = globalSize[i]
+			case i == split:
+				chunk.size[i] = step
+				if remaining := globalSize[i] - position[i]; remaining < step {
+					chunk.size[i] = remaining
+				}
+			default:
+				chunk.size[i] = local[i]
+			}
+			chunk.offset[i] = offset[i] + position[i]
+		}
+		chunks = append(chunks, chunk)
+
+		i := split
+		for ; i < dims; i++ {
+			if i == split {
+				position[i] += step
+			} else {
+				position[i] += local[i]
+			}
+			if position[i] < globalSize[i] {
+				break
+			}
+			position[i] = 0
+		}
+		if i == dims {
+			break
+		}
+	}
+
+	return chunks, nil
 }
@@ -2,8 +2,10 @@ package cl11
 
 import (
 	"encoding/binary"
+	"errors"
 	"math/rand"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -162,3 +164,481 @@ func TestKernel(t *testing.T) {
 		releaseAll(toRelease, t)
 	}
 }
+
+func TestNDRangeChunks(t *testing.T) {
+	tests := []struct {
+		offset, global, local []int
+		maxItems              int
+		want                  []ndRangeChunk
+	}{
+		{
+			nil, []int{100}, []int{10}, 1000,
+			[]ndRangeChunk{{[]int{0}, []int{100}}},
+		},
+		{
+			[]int{5}, []int{100}, []int{10}, 45,
+			[]ndRangeChunk{
+				{[]int{5}, []int{40}},
+				{[]int{45}, []int{40}},
+				{[]int{85}, []int{20}},
+			},
+		},
+		{
+			nil, []int{8, 4}, []int{4, 2}, 16,
+			[]ndRangeChunk{
+				{[]int{0, 0}, []int{8, 2}},
+				{[]int{0, 2}, []int{8, 2}},
+			},
+		},
+		{
+			nil, []int{8, 4}, []int{4, 2}, 8,
+			[]ndRangeChunk{
+				{[]int{0, 0}, []int{4, 2}},
+				{[]int{4, 0}, []int{4, 2}},
+				{[]int{0, 2}, []int{4, 2}},
+				{[]int{4, 2}, []int{4, 2}},
+			},
+		},
+		{
+			nil, []int{8, 4}, nil, 4,
+			[]ndRangeChunk{
+				{[]int{0, 0}, []int{4, 1}},
+				{[]int{4, 0}, []int{4, 1}},
+				{[]int{0, 1}, []int{4, 1}},
+				{[]int{4, 1}, []int{4, 1}},
+				{[]int{0, 2}, []int{4, 1}},
+				{[]int{4, 2}, []int{4, 1}},
+				{[]int{0, 3}, []int{4, 1}},
+				{[]int{4, 3}, []int{4, 1}},
+			},
+		},
+	}
+	for i, test := range tests {
+		got, err := ndRangeChunks(test.offset, test.global, test.local, test.maxItems)
+		if err != nil {
+			t.Error(i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: got %v, want %v", i, got, test.want)
+		}
+	}
+
+	_, err := ndRangeChunks(nil, []int{64}, []int{32}, 16)
+	if err != ErrInvalidChunkSize {
+		t.Error("expected", ErrInvalidChunkSize, "got", err)
+	}
+	_, err = ndRangeChunks(nil, []int{8, 4}, []int{4, 2}, 6)
+	if err != ErrInvalidChunkSize {
+		t.Error("expected", ErrInvalidChunkSize, "got", err)
+	}
+}
+
+func TestEnqueueNDRangeKernelChunkedAutotuned(t *testing.T) {
+	d := &Device{Name: "test"}
+	k := &Kernel{FunctionName: "index"}
+	a := &Autotuner{results: map[string][]int{autotuneKey(d, k, []int{96}): {32}}}
+
+	// Capture the first launch and veto it.
+	var calls []string
+	veto := errors.New("veto")
+	ri := &recordingInterceptor{name: "chunk", calls: &calls, veto: veto}
+	cq := &CommandQueue{Device: d, Autotuner: a}
+	cq.AddInterceptor(ri)
+
+	err := cq.EnqueueNDRangeKernelChunked(k, nil, []int{96}, nil, 50, nil, nil)
+	if err != veto {
+		t.Fatal("expected veto, got", err)
+	}
+	info := ri.infos[0]
+	if !reflect.DeepEqual(info.GlobalSize, []int{32}) || !reflect.DeepEqual(info.LocalSize, []int{32}) {
+		t.Error("got global size", info.GlobalSize, "local size", info.LocalSize)
+	}
+}
+
+var indexKernel = `
+__kernel void index(__global int* out)
+{
+	out[get_global_id(0)] = get_global_id(0);
+}
+`
+
+func TestEnqueueNDRangeKernelChunked(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 1024
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		host0, err := ctx.CreateHostBuffer(int64(elements*4), MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, host0)
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		err = kernel.SetArguments(host0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		var event Event
+		err = cq.EnqueueNDRangeKernelChunked(kernel, nil, []int{elements}, []int{1}, 100, nil, &event)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, &event)
+
+		err = event.Wait()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		map0, err := cq.EnqueueMapBuffer(host0, Blocking, MapRead, 0, int64(elements*4), nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		got := make([]int32, elements)
+		err = binary.Read(map0, device.ByteOrder, got)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		for i := range got {
+			if got[i] != int32(i) {
+				t.Error("values mismatch at", i)
+				break
+			}
+		}
+
+		err = cq.EnqueueUnmapBuffer(map0, nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+func TestKernelPool(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 256
+		workers := 8
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		buffers := make([]*Buffer, workers)
+		for i := range buffers {
+			buffers[i], err = ctx.CreateHostBuffer(int64(elements*4), MemReadWrite)
+			if err != nil {
+				break
+			}
+			toRelease = append(toRelease, buffers[i])
+		}
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		pool := NewKernelPool(kernel)
+		var wg sync.WaitGroup
+		for i := range buffers {
+			wg.Add(1)
+			go func(b *Buffer) {
+				defer wg.Done()
+				k, err := pool.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer pool.Put(k)
+				err = k.SetArguments(b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var event Event
+				err = cq.EnqueueNDRangeKernel(k, nil, []int{elements}, nil, nil, &event)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				err = event.Wait()
+				if err != nil {
+					t.Error(err)
+				}
+				event.Release()
+			}(buffers[i])
+		}
+		wg.Wait()
+
+		err = pool.Release()
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, b := range buffers {
+			map0, err := cq.EnqueueMapBuffer(b, Blocking, MapRead, 0, int64(elements*4), nil, nil)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			got := make([]int32, elements)
+			err = binary.Read(map0, device.ByteOrder, got)
+			if err != nil {
+				t.Error(err)
+			}
+			for i := range got {
+				if got[i] != int32(i) {
+					t.Error("values mismatch at", i)
+					break
+				}
+			}
+			err = cq.EnqueueUnmapBuffer(map0, nil, nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+var fillKernel = `
+__kernel void fill(__global int* out, int value)
+{
+	out[get_global_id(0)] = value;
+}
+`
+
+func TestKernelClone(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 256
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		program, err := ctx.CreateProgramWithSource([]byte(fillKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("fill")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		buffer, err := ctx.CreateHostBuffer(int64(elements*4), MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, buffer)
+
+		// The clone must get the value set, not what the pointer points to
+		// when cloning.
+		value := int32(7)
+		err = kernel.SetArguments(buffer, &value)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		value = 9
+
+		clone, err := kernel.Clone()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, clone)
+
+		err = cq.EnqueueNDRangeKernel(clone, nil, []int{elements}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		map0, err := cq.EnqueueMapBuffer(buffer, Blocking, MapRead, 0, int64(elements*4), nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		got := make([]int32, elements)
+		err = binary.Read(map0, device.ByteOrder, got)
+		if err != nil {
+			t.Error(err)
+		}
+		for i := range got {
+			if got[i] != 7 {
+				t.Error("got", got[i], "at", i, "want 7")
+				break
+			}
+		}
+		err = cq.EnqueueUnmapBuffer(map0, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+func TestKernelSetArgRecorded(t *testing.T) {
+	var k Kernel
+	var value interface{} = int32(7)
+	err := k.SetArg(1, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.args) != 2 || k.args[0] != nil || k.args[1] != int32(7) {
+		t.Error("unexpected recorded arguments", k.args)
+	}
+
+	// Only the addressable copy of the value is allocated.
+	allocs := testing.AllocsPerRun(100, func() {
+		err = k.SetArg(1, value)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if allocs > 1 {
+		t.Errorf("SetArg made %v allocations", allocs)
+	}
+
+	pointed := int32(8)
+	err = k.SetArg(0, &pointed)
+	if err != nil {
+		t.Error(err)
+	}
+	pointed = 9
+	if k.args[0] != int32(8) {
+		t.Error("got recorded", k.args[0], "want 8")
+	}
+}
@@ -0,0 +1,75 @@
+package cl11
+
+import "sync"
+
+// A KernelPool hands out independent instances of a kernel so that a single
+// built program can be used by many goroutines concurrently.
+//
+// Instances are created with Kernel.Clone and therefore start with the
+// arguments set on the pool's kernel when the instance was created. Returned
+// instances keep whatever arguments their last user set, so users should set
+// all arguments they depend on.
+type KernelPool struct {
+
+	// The kernel instances are cloned from. It should not be modified once the
+	// pool is in use.
+	Kernel *Kernel
+
+	mu   sync.Mutex
+	free []*Kernel
+	all  []*Kernel
+}
+
+// Creates a pool of instances of the kernel.
+func NewKernelPool(k *Kernel) *KernelPool {
+	return &KernelPool{Kernel: k}
+}
+
+// Returns an instance of the kernel for exclusive use by the caller until it
+// is returned with Put.
+func (kp *KernelPool) Get() (*Kernel, error) {
+
+	kp.mu.Lock()
+	if n := len(kp.free); n > 0 {
+		k := kp.free[n-1]
+		kp.free = kp.free[:n-1]
+		kp.mu.Unlock()
+		return k, nil
+	}
+	kp.mu.Unlock()
+
+	k, err := kp.Kernel.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	kp.mu.Lock()
+	kp.all = append(kp.all, k)
+	kp.mu.Unlock()
+
+	return k, nil
+}
+
+// Returns an instance obtained from Get to the pool.
+func (kp *KernelPool) Put(k *Kernel) {
+	kp.mu.Lock()
+	kp.free = append(kp.free, k)
+	kp.mu.Unlock()
+}
+
+// Releases every instance created by the pool. The pool's kernel is not
+// released. Instances must not be in use.
+func (kp *KernelPool) Release() error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	var err error
+	for _, k := range kp.all {
+		if releaseErr := k.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	kp.all = nil
+	kp.free = nil
+	return err
+}
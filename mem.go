@@ -89,6 +89,19 @@ const (
 func (cq *CommandQueue) EnqueueCopyImageToBuffer(src *Image, dst *Buffer, r *Rect, offset int, waitList []*Event,
 	e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandCopyImageToBuffer, Images: []*Image{src}, Buffers: []*Buffer{dst},
+			Offsets: []int64{int64(offset)}, Rect: r, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		src, dst, offset, r = info.Images[0], info.Buffers[0], int(info.Offsets[0]), info.Rect
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -101,13 +114,27 @@ func (cq *CommandQueue) EnqueueCopyImageToBuffer(src *Image, dst *Buffer, r *Rec
 	err := clw.EnqueueCopyImageToBuffer(cq.id, src.id, dst.id, r.Src.origin(), r.region(), clw.Size(offset), events,
 		event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", int64(r.size())*int64(src.ElementSize))
+	return cq.interceptAfter(info, err)
 }
 
 // Only the destination and region are used from the rectangle.
 func (cq *CommandQueue) EnqueueCopyBufferToImage(src *Buffer, dst *Image, offset int, r *Rect, waitList []*Event,
 	e *Event) error {
 
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandCopyBufferToImage, Buffers: []*Buffer{src}, Images: []*Image{dst},
+			Offsets: []int64{int64(offset)}, Rect: r, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		src, dst, offset, r = info.Buffers[0], info.Images[0], int(info.Offsets[0]), info.Rect
+		waitList, e = info.WaitList, info.Event
+	}
+
+	e, internal := cq.profiledEvent(e)
+
 	var event *clw.Event
 	if e != nil {
 		event = &e.id
@@ -120,5 +147,6 @@ func (cq *CommandQueue) EnqueueCopyBufferToImage(src *Buffer, dst *Image, offset
 	err := clw.EnqueueCopyBufferToImage(cq.id, src.id, dst.id, clw.Size(offset), r.Dst.origin(), r.region(), events,
 		event)
 	cq.releaseEvents(events)
-	return err
+	cq.profileCommand(e, internal, err, "", int64(r.size())*int64(dst.ElementSize))
+	return cq.interceptAfter(info, err)
 }
@@ -0,0 +1,552 @@
+package cl11
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// A MultiQueue owns a command queue for every device in a context and executes
+// an ND range split across the devices.
+//
+// The range is split along its highest dimension, the slowest varying index in
+// a row-major layout, into one part per device. Buffers that hold the inputs or
+// outputs of the work-items are passed as partitions so each device is given a
+// sub-buffer covering only its part.
+type MultiQueue struct {
+
+	// The context the command queues were created on.
+	Context *Context
+
+	// A command queue for each device of the context, in the same order.
+	Queues []*CommandQueue
+
+	// How the range is split between the devices.
+	LoadBalance LoadBalance
+
+	mu sync.Mutex
+
+	// Measured work-items per nanosecond for each queue, zero until measured.
+	throughput []float64
+
+	// Instances of the kernels launched, one per queue.
+	kernels map[*Kernel][]*Kernel
+}
+
+// How a MultiQueue splits an ND range between its devices.
+type LoadBalance int
+
+const (
+	// Split in proportion to each device's compute units times its clock
+	// frequency.
+	StaticLoadBalance LoadBalance = iota
+
+	// Split in proportion to the throughput measured in previous launches,
+	// starting with the static split. Queues with profiling enabled are
+	// measured using the command's profiling information, others from the
+	// time the command was flushed until it completed.
+	DynamicLoadBalance
+)
+
+// A Partition is a buffer kernel argument that is split between the devices of
+// a MultiQueue along with the ND range.
+//
+// Each device is given a sub-buffer that starts at the first index of its part
+// of the highest dimension. Since the launch keeps the global offset of its
+// part, kernels index a partitioned buffer using get_global_id(d) -
+// get_global_offset(d) in the highest dimension d.
+type Partition struct {
+
+	// The index of the kernel argument.
+	Arg int
+
+	// The buffer that is split, its first byte belongs to the global offset of
+	// the range. If it is a sub-buffer its origin must satisfy every device's
+	// sub-buffer alignment.
+	Buffer *Buffer
+
+	// The number of bytes per index of the highest dimension, e.g. the size of
+	// a row of a two dimensional range.
+	Stride int64
+
+	// The flags of the sub-buffers, if zero the access flags of the buffer are
+	// used.
+	Flags MemFlags
+}
+
+// Creates a multi-queue with a command queue for every device of the context.
+func (c *Context) CreateMultiQueue(cqp CommandQueueProperties) (*MultiQueue, error) {
+
+	mq := &MultiQueue{
+		Context:    c,
+		throughput: make([]float64, len(c.Devices)),
+		kernels:    make(map[*Kernel][]*Kernel),
+	}
+
+	for _, d := range c.Devices {
+		cq, err := c.CreateCommandQueue(d, cqp)
+		if err != nil {
+			mq.Release()
+			return nil, err
+		}
+		mq.Queues = append(mq.Queues, cq)
+	}
+
+	return mq, nil
+}
+
+// A device's part of a split ND range.
+type multiQueuePart struct {
+	queue  int
+	start  int
+	count  int
+	event  *Event
+	issued time.Time
+	end    time.Time
+
+	// Whether the part's queue has profiling enabled.
+	profiled bool
+}
+
+// Enqueues a kernel execution split across the devices.
+//
+// Each device executes its part of the highest dimension with the kernel's
+// arguments, except for the partitions, on its own instance of the kernel. The
+// program must have been built for every device. The parts contain a whole
+// number of work-groups and start at an index that satisfies every device's
+// sub-buffer alignment. Each part waits on waitList. The event, if not nil, is
+// a user event that completes once all parts have completed, or is terminated
+// with ExecStatusErrorForEventsInWaitList if any part failed.
+//
+// LocalSize is optional, if it is omitted the work-group size is left to the
+// implementation.
+func (mq *MultiQueue) EnqueueNDRangeKernel(k *Kernel, partitions []Partition, globalOffset, globalSize,
+	localSize []int, waitList []*Event, e *Event) error {
+
+	dims := len(globalSize)
+	if dims == 0 || globalSize[dims-1] <= 0 {
+		return fmt.Errorf("cl: MultiQueue.EnqueueNDRangeKernel: empty global size")
+	}
+	split := dims - 1
+
+	granularity := 1
+	if localSize != nil && localSize[split] > 0 {
+		granularity = localSize[split]
+	}
+	for _, p := range partitions {
+		if p.Stride <= 0 || int64(globalSize[split])*p.Stride > p.Buffer.Size {
+			return fmt.Errorf("cl: MultiQueue.EnqueueNDRangeKernel: argument %d buffer too small", p.Arg)
+		}
+		for _, d := range mq.Context.Devices {
+			align := int64(d.MemBaseAddrAlign / 8)
+			if p.Buffer.Buffer != nil && align > 0 && p.Buffer.Origin%align != 0 {
+				return fmt.Errorf("cl: MultiQueue.EnqueueNDRangeKernel: argument %d sub-buffer origin %d is not "+
+					"aligned to %d bytes on %s", p.Arg, p.Buffer.Origin, align, d.Name)
+			}
+			granularity = lcm(granularity, alignmentGranularity(p.Stride, align))
+		}
+	}
+
+	var combined *Event
+	if e != nil {
+		var err error
+		combined, err = mq.Context.CreateUserEvent()
+		if err != nil {
+			return err
+		}
+
+		// Keep the user event for the goroutine, the caller may release theirs
+		// before it is set. Retained before any part is enqueued so a failure
+		// can not leave parts running.
+		err = combined.Retain()
+		if err != nil {
+			combined.SetError(execStatusErrorForEventsInWaitListCode)
+			combined.Release()
+			return err
+		}
+	}
+
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	var parts []*multiQueuePart
+	for i, count := range splitRange(globalSize[split], granularity, mq.weights()) {
+		if count > 0 {
+			start := 0
+			if n := len(parts); n > 0 {
+				start = parts[n-1].start + parts[n-1].count
+			}
+			parts = append(parts, &multiQueuePart{queue: i, start: start, count: count})
+		}
+	}
+
+	err := mq.enqueueParts(k, partitions, parts, globalOffset, globalSize, localSize, waitList)
+	if err != nil {
+		for _, part := range parts {
+			if part.event != nil {
+				part.event.Release()
+			}
+		}
+		if combined != nil {
+			combined.SetError(execStatusErrorForEventsInWaitListCode)
+			combined.Release()
+			combined.Release()
+		}
+		return err
+	}
+
+	if combined != nil {
+		*e = *combined
+	}
+
+	// Record when each part completes, the callbacks have all been called once
+	// the wait group is done. Parts without a callback are not measured.
+	var completed sync.WaitGroup
+	for _, part := range parts {
+		part := part
+		completed.Add(1)
+		callbackErr := part.event.SetCallback(func(e *Event, err error, userData interface{}) {
+			part.end = time.Now()
+			completed.Done()
+		}, nil)
+		if callbackErr != nil {
+			completed.Done()
+		}
+	}
+
+	go mq.complete(parts, globalSize, &completed, combined)
+
+	return nil
+}
+
+// Enqueues each part on its queue.
+func (mq *MultiQueue) enqueueParts(k *Kernel, partitions []Partition, parts []*multiQueuePart, globalOffset,
+	globalSize, localSize []int, waitList []*Event) error {
+
+	instances, err := mq.kernelInstances(k)
+	if err != nil {
+		return err
+	}
+
+	split := len(globalSize) - 1
+	base := 0
+	if globalOffset != nil {
+		base = globalOffset[split]
+	}
+
+	for _, part := range parts {
+		cq := mq.Queues[part.queue]
+		kernel := instances[part.queue]
+
+		// The instance's arguments may be from a previous launch.
+		for i, arg := range k.args {
+			if arg != nil {
+				err = kernel.SetArg(i, arg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		var subBuffers []*Buffer
+		for _, p := range partitions {
+			var sub *Buffer
+			sub, err = p.subBuffer(part.start, part.count)
+			if err != nil {
+				break
+			}
+			subBuffers = append(subBuffers, sub)
+			err = kernel.SetArg(p.Arg, sub)
+			if err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			offset := make([]int, len(globalSize))
+			if globalOffset != nil {
+				copy(offset, globalOffset)
+			}
+			offset[split] = base + part.start
+			size := append([]int(nil), globalSize...)
+			size[split] = part.count
+
+			part.event = &Event{}
+			err = cq.EnqueueNDRangeKernel(kernel, offset, size, localSize, waitList, part.event)
+			if err != nil {
+				part.event = nil
+			}
+		}
+
+		// The enqueued command holds its own references to the sub-buffers.
+		for _, sub := range subBuffers {
+			if releaseErr := sub.Release(); err == nil {
+				err = releaseErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cq.Flush()
+		if err != nil {
+			return err
+		}
+		part.issued = time.Now()
+		part.profiled = cq.Properties&QueueProfilingEnable != 0
+	}
+
+	return nil
+}
+
+// Creates the sub-buffer for a part starting at index start of the highest
+// dimension, relative to the global offset.
+func (p *Partition) subBuffer(start, count int) (*Buffer, error) {
+
+	// Sub-buffers of sub-buffers are not allowed.
+	b, origin := p.Buffer, int64(0)
+	if b.Buffer != nil {
+		b, origin = b.Buffer, b.Origin
+	}
+
+	flags := p.Flags
+	if flags == 0 {
+		flags = p.Buffer.Flags & (MemReadWrite | MemWriteOnly | MemReadOnly)
+	}
+
+	return b.CreateSubBuffer(flags, origin+int64(start)*p.Stride, int64(count)*p.Stride)
+}
+
+// Waits for the parts to complete, updates the measured throughput, releases
+// the parts' events and sets the status of the combined event.
+func (mq *MultiQueue) complete(parts []*multiQueuePart, globalSize []int, completed *sync.WaitGroup,
+	combined *Event) {
+
+	completed.Wait()
+
+	events := make([]*Event, len(parts))
+	for i, part := range parts {
+		events[i] = part.event
+	}
+	failed := WaitForEvents(events...) != nil
+
+	// Work-items per index of the highest dimension.
+	items := 1
+	for _, size := range globalSize[:len(globalSize)-1] {
+		items *= size
+	}
+
+	mq.mu.Lock()
+	for _, part := range parts {
+
+		_, eventErr, err := part.event.Status()
+		if eventErr != nil || err != nil {
+			failed = true
+			continue
+		}
+
+		var duration float64
+		if part.profiled && part.event.GetProfilingInfo() == nil {
+			duration = float64(part.event.End - part.event.Start)
+		} else if !part.end.IsZero() {
+			duration = float64(part.end.Sub(part.issued))
+		}
+		if duration <= 0 {
+			continue
+		}
+
+		throughput := float64(part.count*items) / duration
+		if previous := mq.throughput[part.queue]; previous > 0 {
+			throughput = (previous + throughput) / 2
+		}
+		mq.throughput[part.queue] = throughput
+	}
+	mq.mu.Unlock()
+
+	for _, part := range parts {
+		part.event.Release()
+	}
+
+	if combined != nil {
+		if failed {
+			combined.SetError(execStatusErrorForEventsInWaitListCode)
+		} else {
+			combined.SetComplete()
+		}
+		combined.Release()
+	}
+}
+
+// Returns the fraction of an ND range each queue would currently be given,
+// before rounding to whole work-groups.
+func (mq *MultiQueue) Shares() []float64 {
+
+	mq.mu.Lock()
+	weights := mq.weights()
+	mq.mu.Unlock()
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+	return weights
+}
+
+// Returns the relative speed of each queue. Must be called with mu held.
+func (mq *MultiQueue) weights() []float64 {
+
+	static := make([]float64, len(mq.Queues))
+	for i, cq := range mq.Queues {
+		static[i] = math.Max(1, float64(cq.Device.MaxComputeUnits)*float64(cq.Device.MaxClockFrequency))
+	}
+	if mq.LoadBalance == StaticLoadBalance {
+		return static
+	}
+
+	// Queues that have not been measured yet are assumed to relate to the
+	// measured ones as their static weights do.
+	var measured, measuredStatic float64
+	for i, throughput := range mq.throughput {
+		if throughput > 0 {
+			measured += throughput
+			measuredStatic += static[i]
+		}
+	}
+	if measured == 0 {
+		return static
+	}
+
+	weights := make([]float64, len(mq.Queues))
+	for i, throughput := range mq.throughput {
+		if throughput > 0 {
+			weights[i] = throughput
+		} else {
+			weights[i] = static[i] * measured / measuredStatic
+		}
+	}
+	return weights
+}
+
+// Returns the instances of the kernel for each queue, creating them the first
+// time. Must be called with mu held.
+func (mq *MultiQueue) kernelInstances(k *Kernel) ([]*Kernel, error) {
+
+	if instances, ok := mq.kernels[k]; ok {
+		return instances, nil
+	}
+
+	instances := make([]*Kernel, len(mq.Queues))
+	for i := range instances {
+		instance, err := k.Clone()
+		if err != nil {
+			for _, created := range instances[:i] {
+				created.Release()
+			}
+			return nil, err
+		}
+		instances[i] = instance
+	}
+
+	mq.kernels[k] = instances
+	return instances, nil
+}
+
+// Splits total indices into parts in proportion to the weights. The
+// boundaries between parts are rounded to a multiple of granularity, so only
+// the last part may not be a multiple of it.
+func splitRange(total, granularity int, weights []float64) []int {
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+
+	counts := make([]int, len(weights))
+	var cumulative float64
+	previous := 0
+	for i, w := range weights {
+		cumulative += w
+		boundary := total
+		if i < len(weights)-1 {
+			units := math.Floor(float64(total)*cumulative/sum/float64(granularity) + 0.5)
+			if boundary > int(units)*granularity {
+				boundary = int(units) * granularity
+			}
+		}
+		counts[i] = boundary - previous
+		previous = boundary
+	}
+
+	return counts
+}
+
+// Returns the smallest number of indices whose size in bytes is a multiple of
+// the alignment.
+func alignmentGranularity(stride, alignment int64) int {
+	if alignment <= 1 {
+		return 1
+	}
+	return int(alignment / gcd(alignment, stride))
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func lcm(a, b int) int {
+	return a / int(gcd(int64(a), int64(b))) * b
+}
+
+// Issues the commands of every queue, see CommandQueue.Flush.
+func (mq *MultiQueue) Flush() error {
+	var err error
+	for _, cq := range mq.Queues {
+		if flushErr := cq.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// Blocks until the commands of every queue have completed, see
+// CommandQueue.Finish.
+func (mq *MultiQueue) Finish() error {
+	var err error
+	for _, cq := range mq.Queues {
+		if finishErr := cq.Finish(); err == nil {
+			err = finishErr
+		}
+	}
+	return err
+}
+
+// Releases the command queues and the kernel instances. Enqueued commands must
+// have completed.
+func (mq *MultiQueue) Release() error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	var err error
+	for _, instances := range mq.kernels {
+		for _, k := range instances {
+			if releaseErr := k.Release(); err == nil {
+				err = releaseErr
+			}
+		}
+	}
+	mq.kernels = make(map[*Kernel][]*Kernel)
+	for _, cq := range mq.Queues {
+		if releaseErr := cq.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	mq.Queues = nil
+	return err
+}
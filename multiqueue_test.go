@@ -0,0 +1,221 @@
+package cl11
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitRange(t *testing.T) {
+	for _, test := range []struct {
+		total, granularity int
+		weights            []float64
+		want               []int
+	}{
+		{100, 1, []float64{1, 1}, []int{50, 50}},
+		{100, 1, []float64{3, 1}, []int{75, 25}},
+		{100, 16, []float64{1, 1}, []int{48, 52}},
+		{96, 16, []float64{2, 1}, []int{64, 32}},
+		{10, 16, []float64{1, 1}, []int{0, 10}},
+		{100, 16, []float64{1, 2, 1}, []int{32, 48, 20}},
+		{40, 16, []float64{1, 1, 1}, []int{16, 16, 8}},
+		{64, 8, []float64{1, 0, 1}, []int{32, 0, 32}},
+	} {
+		got := splitRange(test.total, test.granularity, test.weights)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitRange(%d, %d, %v) = %v, want %v", test.total, test.granularity, test.weights, got,
+				test.want)
+		}
+	}
+}
+
+func TestAlignmentGranularity(t *testing.T) {
+	if g := alignmentGranularity(4, 128); g != 32 {
+		t.Error("got", g, "want 32")
+	}
+	if g := alignmentGranularity(48, 128); g != 8 {
+		t.Error("got", g, "want 8")
+	}
+	if g := alignmentGranularity(256, 128); g != 1 {
+		t.Error("got", g, "want 1")
+	}
+	if l := lcm(6, alignmentGranularity(4, 128)); l != 96 {
+		t.Error("got", l, "want 96")
+	}
+}
+
+func TestMultiQueueShares(t *testing.T) {
+	fast := &Device{MaxComputeUnits: 30, MaxClockFrequency: 1000}
+	slow := &Device{MaxComputeUnits: 10, MaxClockFrequency: 1000}
+	third := &Device{MaxComputeUnits: 10, MaxClockFrequency: 1000}
+	mq := &MultiQueue{
+		Queues:     []*CommandQueue{{Device: fast}, {Device: slow}, {Device: third}},
+		throughput: make([]float64, 3),
+	}
+
+	checkShares := func(want ...float64) {
+		t.Helper()
+		got := mq.Shares()
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > 1e-9 {
+				t.Errorf("got shares %v, want %v", got, want)
+				return
+			}
+		}
+	}
+
+	checkShares(0.6, 0.2, 0.2)
+
+	// Measurements are ignored unless balancing dynamically.
+	mq.throughput[0], mq.throughput[1] = 1, 3
+	checkShares(0.6, 0.2, 0.2)
+
+	// The unmeasured device is assumed to be as fast relative to the others as
+	// its static weight implies.
+	mq.LoadBalance = DynamicLoadBalance
+	checkShares(1.0/5, 3.0/5, 1.0/5)
+}
+
+func TestMultiQueueSubBufferAlignment(t *testing.T) {
+	device := &Device{Name: "device", MemBaseAddrAlign: 1024}
+	mq := &MultiQueue{Context: &Context{Devices: []*Device{device}}}
+	root := &Buffer{Size: 4096}
+	partition := Partition{Buffer: &Buffer{Buffer: root, Origin: 4, Size: 1024}, Stride: 4}
+
+	err := mq.EnqueueNDRangeKernel(nil, []Partition{partition}, nil, []int{256}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "not aligned to 128 bytes") {
+		t.Error("expected an unaligned sub-buffer error, got", err)
+	}
+}
+
+var partitionKernel = `
+__kernel void partition(__global int* out, int add)
+{
+	out[get_global_id(0) - get_global_offset(0)] = get_global_id(0) + add;
+}
+`
+
+func TestMultiQueue(t *testing.T) {
+	allPlatforms, err := GetPlatforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, platform := range allPlatforms {
+		t.Log(len(platform.Devices), "devices on", platform.Name)
+
+		var toRelease []Object
+		elements := 4096
+		offset := 100
+
+		ctx, err := CreateContext(platform.Devices, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		out, err := ctx.CreateHostBuffer(int64(elements*4), MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, out)
+
+		program, err := ctx.CreateProgramWithSource([]byte(partitionKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build(platform.Devices, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("partition")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		mq, err := ctx.CreateMultiQueue(0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		mq.LoadBalance = DynamicLoadBalance
+
+		partitions := []Partition{{Arg: 0, Buffer: out, Stride: 4}}
+
+		// Launch twice so the second launch is split by measured throughput.
+		for add := int32(0); add < 2; add++ {
+			err = kernel.SetArguments(out, add)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+
+			var event Event
+			err = mq.EnqueueNDRangeKernel(kernel, partitions, []int{offset}, []int{elements}, []int{16}, nil,
+				&event)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+
+			err = event.Wait()
+			if err != nil {
+				t.Error(err)
+			}
+			err = event.Release()
+			if err != nil {
+				t.Error(err)
+			}
+			t.Log("shares", mq.Shares())
+
+			cq := mq.Queues[0]
+			mapped, err := cq.EnqueueMapBuffer(out, Blocking, MapRead, 0, int64(elements*4), nil, nil)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+
+			got := make([]int32, elements)
+			err = binary.Read(mapped, cq.Device.ByteOrder, got)
+			if err != nil {
+				t.Error(err)
+			}
+			for i := range got {
+				if got[i] != int32(offset+i)+add {
+					t.Error("values mismatch at", i)
+					break
+				}
+			}
+
+			err = cq.EnqueueUnmapBuffer(mapped, nil, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			err = cq.Finish()
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		err = mq.Release()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll(toRelease, t)
+	}
+}
@@ -0,0 +1,136 @@
+package cl11
+
+import (
+	"sync"
+	"unsafe"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// A Go function executed by the device as a native kernel.
+//
+// Args is a copy of the arguments passed to EnqueueNativeKernel and mems holds
+// a host pointer for each of the memory objects, in the same order. Both are
+// only valid for the duration of the call.
+type NativeKernel func(args []byte, mems []unsafe.Pointer)
+
+// Native kernels waiting to be executed, keyed by the handle written at the
+// start of their argument block.
+var nativeKernels = struct {
+	sync.Mutex
+	next    uintptr
+	kernels map[uintptr]*nativeKernel
+}{kernels: make(map[uintptr]*nativeKernel)}
+
+type nativeKernel struct {
+	fn      NativeKernel
+	mems    int
+	argSize int
+}
+
+// Layout of the argument block passed to the implementation: the handle, a
+// pointer sized slot per memory object and then the caller's arguments.
+const nativeKernelHandleSize = unsafe.Sizeof(uintptr(0))
+
+// Enqueues a command to execute a native Go function not compiled using the
+// OpenCL compiler.
+//
+// The args are copied by the implementation before EnqueueNativeKernel returns.
+// Each buffer in memObjects is replaced by a host pointer to its contents when
+// fn is called. The function is kept reachable until the command has executed
+// or terminated. Devices without ExecNativeKernel in their ExecCapabilities
+// return ErrNativeKernelUnsupported.
+func (cq *CommandQueue) EnqueueNativeKernel(fn NativeKernel, args []byte, memObjects []*Buffer, waitList []*Event,
+	e *Event) error {
+
+	var info *EnqueueInfo
+	if cq.Interceptors != nil {
+		info = &EnqueueInfo{CommandType: CommandNativeKernel, Buffers: memObjects, WaitList: waitList, Event: e}
+		if err := cq.interceptBefore(info); err != nil {
+			return cq.interceptAfter(info, err)
+		}
+		memObjects = info.Buffers
+		waitList, e = info.WaitList, info.Event
+	}
+
+	if cq.Device.ExecCapabilities&ExecNativeKernel == 0 {
+		return cq.interceptAfter(info, ErrNativeKernelUnsupported)
+	}
+
+	// An event is always required to know when the function can be forgotten.
+	event := e
+	if event == nil {
+		event = &Event{}
+	}
+	event.Context = cq.Context
+	event.CommandType = CommandNativeKernel
+	event.CommandQueue = cq
+
+	nativeKernels.Lock()
+	nativeKernels.next++
+	handle := nativeKernels.next
+	nativeKernels.kernels[handle] = &nativeKernel{fn: fn, mems: len(memObjects), argSize: len(args)}
+	nativeKernels.Unlock()
+
+	memOffset := int(nativeKernelHandleSize)
+	argOffset := memOffset + len(memObjects)*int(nativeKernelHandleSize)
+	block := make([]byte, argOffset+len(args))
+	*(*uintptr)(unsafe.Pointer(&block[0])) = handle
+	copy(block[argOffset:], args)
+
+	mems := make([]clw.Mem, len(memObjects))
+	memLocations := make([]unsafe.Pointer, len(memObjects))
+	for i := range memObjects {
+		mems[i] = memObjects[i].id
+		memLocations[i] = unsafe.Pointer(&block[memOffset+i*int(nativeKernelHandleSize)])
+	}
+
+	events := cq.createEvents(waitList)
+	err := clw.EnqueueNativeKernel(cq.id, callNativeKernel, unsafe.Pointer(&block[0]), clw.Size(len(block)), mems,
+		memLocations, events, &event.id)
+	cq.releaseEvents(events)
+	if err != nil {
+		forgetNativeKernel(handle)
+		return cq.interceptAfter(info, err)
+	}
+	cq.profileCommand(event, false, nil, "", 0)
+
+	// Forget the function once the command has finished, in case it was
+	// terminated before it could run.
+	err = event.SetCallback(func(event *Event, err error, userData interface{}) {
+		forgetNativeKernel(handle)
+		if e == nil {
+			event.Release()
+		}
+	}, nil)
+	return cq.interceptAfter(info, err)
+}
+
+// The trampoline called by the implementation, finds the Go function and
+// unpacks the argument block.
+func callNativeKernel(block unsafe.Pointer) {
+
+	handle := *(*uintptr)(block)
+	nativeKernels.Lock()
+	nk := nativeKernels.kernels[handle]
+	nativeKernels.Unlock()
+	if nk == nil {
+		return
+	}
+	forgetNativeKernel(handle)
+
+	n := nk.mems
+	mems := make([]unsafe.Pointer, n)
+	copy(mems, (*[1 << 30]unsafe.Pointer)(unsafe.Pointer(uintptr(block) + nativeKernelHandleSize))[:n:n])
+
+	n = nk.argSize
+	args := (*[1 << 30]byte)(unsafe.Pointer(uintptr(block) + nativeKernelHandleSize*uintptr(1+nk.mems)))[:n:n]
+
+	nk.fn(args, mems)
+}
+
+func forgetNativeKernel(handle uintptr) {
+	nativeKernels.Lock()
+	delete(nativeKernels.kernels, handle)
+	nativeKernels.Unlock()
+}
@@ -0,0 +1,88 @@
+package cl11
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestEnqueueNativeKernel(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		size := int64(1024)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, 0)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		host0, err := ctx.CreateHostBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, host0)
+
+		args := make([]byte, 4)
+		binary.LittleEndian.PutUint32(args, 42)
+		fill := func(args []byte, mems []unsafe.Pointer) {
+			value := byte(binary.LittleEndian.Uint32(args))
+			data := (*[1 << 30]byte)(mems[0])[:size:size]
+			for i := range data {
+				data[i] = value
+			}
+		}
+
+		var event Event
+		err = cq.EnqueueNativeKernel(fill, args, []*Buffer{host0}, nil, &event)
+		if device.ExecCapabilities&ExecNativeKernel == 0 {
+			if err != ErrNativeKernelUnsupported {
+				t.Error("expected", ErrNativeKernelUnsupported, "got", err)
+			}
+			releaseAll(toRelease, t)
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, &event)
+
+		map0, err := cq.EnqueueMapBuffer(host0, Blocking, MapRead, 0, size, []*Event{&event}, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		for i, v := range map0.Bytes() {
+			if v != 42 {
+				t.Error("values mismatch at", i)
+				break
+			}
+		}
+
+		err = cq.EnqueueUnmapBuffer(map0, nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
@@ -0,0 +1,338 @@
+package cl11
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A Profiler records the timing of every command enqueued to the command
+// queues it is attached to, for export as a Chrome trace or a summary table.
+//
+// Each command's event is retained until Collect queries its profiling
+// information, so Collect should be called periodically in long running
+// programs.
+type Profiler struct {
+	mu      sync.Mutex
+	queues  []*CommandQueue
+	pending []profiledCommand
+	records []ProfileRecord
+}
+
+// The profiling information of a command.
+type ProfileRecord struct {
+
+	// The queue the command was enqueued to.
+	CommandQueue *CommandQueue
+
+	// The type of the command.
+	CommandType CommandType
+
+	// The kernel function name for kernel commands.
+	Kernel string
+
+	// The number of bytes copied or mapped for memory commands.
+	Bytes int64
+
+	// The device times in nanoseconds, see Event.GetProfilingInfo.
+	Queued, Submit, Start, End int64
+
+	// The error the command terminated with, if any. The times are not set if
+	// it is not nil.
+	Err error
+}
+
+// Aggregated execution times of commands of a type or of a kernel.
+type ProfileSummary struct {
+	CommandType CommandType
+	Kernel      string
+
+	// The number of commands, their total bytes and the total, mean, median
+	// and 99th percentile time from start to end.
+	Count int
+	Bytes int64
+	Total time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P99   time.Duration
+}
+
+type profiledCommand struct {
+	cq          *CommandQueue
+	event       *Event
+	commandType CommandType
+	kernel      string
+	bytes       int64
+}
+
+// Creates a profiler and attaches it to the command queues.
+func NewProfiler(queues ...*CommandQueue) (*Profiler, error) {
+	p := &Profiler{}
+	for _, cq := range queues {
+		err := p.Attach(cq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Attaches the profiler to the command queue, which must have been created
+// with QueueProfilingEnable. Commands enqueued from then on are recorded.
+func (p *Profiler) Attach(cq *CommandQueue) error {
+
+	if cq.Properties&QueueProfilingEnable == 0 {
+		return ProfilingInfoNotAvailable
+	}
+
+	p.mu.Lock()
+	p.queues = append(p.queues, cq)
+	p.mu.Unlock()
+	cq.Profiler = p
+
+	return nil
+}
+
+// Returns the event to enqueue a command with and whether it was created for
+// the profiler, which is the case if the queue is profiled and the caller did
+// not ask for an event.
+func (cq *CommandQueue) profiledEvent(e *Event) (*Event, bool) {
+	if e == nil && cq.Profiler != nil {
+		return &Event{}, true
+	}
+	return e, false
+}
+
+// Records a command if the queue is profiled. The profiler takes over the
+// reference of events created by profiledEvent and retains any others. It keeps
+// its own copy of the event since callers may reuse theirs for another command.
+func (cq *CommandQueue) profileCommand(e *Event, internal bool, err error, kernel string, bytes int64) {
+
+	if cq.Profiler == nil || e == nil || err != nil {
+		return
+	}
+	if !internal && e.Retain() != nil {
+		return
+	}
+
+	p := cq.Profiler
+	p.mu.Lock()
+	p.pending = append(p.pending, profiledCommand{
+		cq:          cq,
+		event:       &Event{id: e.id, Context: e.Context, CommandType: e.CommandType, CommandQueue: cq},
+		commandType: e.CommandType,
+		kernel:      kernel,
+		bytes:       bytes,
+	})
+	p.mu.Unlock()
+}
+
+// Waits for the recorded commands to complete, queries their profiling
+// information and releases their events.
+func (p *Profiler) Collect() error {
+
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	var err error
+	records := make([]ProfileRecord, len(pending))
+	for i, pc := range pending {
+
+		r := &records[i]
+		r.CommandQueue = pc.cq
+		r.CommandType = pc.commandType
+		r.Kernel = pc.kernel
+		r.Bytes = pc.bytes
+
+		r.Err = pc.event.Wait()
+		if r.Err == nil {
+			r.Err = pc.event.GetProfilingInfo()
+			r.Queued, r.Submit, r.Start, r.End = pc.event.Queued, pc.event.Submit, pc.event.Start, pc.event.End
+		}
+
+		if releaseErr := pc.event.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+
+	p.mu.Lock()
+	p.records = append(p.records, records...)
+	p.mu.Unlock()
+
+	return err
+}
+
+// Collects any pending commands and returns all the records.
+func (p *Profiler) Records() ([]ProfileRecord, error) {
+	err := p.Collect()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProfileRecord(nil), p.records...), err
+}
+
+// Discards the collected records.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	p.records = nil
+	p.mu.Unlock()
+}
+
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp float64                `json:"ts"`
+	Duration  float64                `json:"dur,omitempty"`
+	Process   int                    `json:"pid"`
+	Thread    int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// Writes the records as Chrome trace_event JSON, which can be viewed with
+// chrome://tracing or Perfetto. Each command queue is shown as a thread and
+// times are relative to the earliest queued command.
+func (p *Profiler) WriteTrace(w io.Writer) error {
+
+	records, err := p.Records()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	queues := append([]*CommandQueue(nil), p.queues...)
+	p.mu.Unlock()
+
+	events := make([]traceEvent, 0, len(records)+len(queues))
+	threads := make(map[*CommandQueue]int, len(queues))
+	for i, cq := range queues {
+		threads[cq] = i + 1
+		events = append(events, traceEvent{
+			Name:    "thread_name",
+			Phase:   "M",
+			Process: 1,
+			Thread:  i + 1,
+			Args:    map[string]interface{}{"name": fmt.Sprintf("%s queue %d", cq.Device.Name, i+1)},
+		})
+	}
+
+	origin := int64(math.MaxInt64)
+	for _, r := range records {
+		if r.Err == nil && r.Queued < origin {
+			origin = r.Queued
+		}
+	}
+
+	for _, r := range records {
+		if r.Err != nil {
+			continue
+		}
+		name := r.CommandType.String()
+		if r.Kernel != "" {
+			name = r.Kernel
+		}
+		args := map[string]interface{}{
+			"queue_latency_us":  float64(r.Start-r.Queued) / 1e3,
+			"submit_latency_us": float64(r.Start-r.Submit) / 1e3,
+		}
+		if r.Bytes != 0 {
+			args["bytes"] = r.Bytes
+		}
+		events = append(events, traceEvent{
+			Name:      name,
+			Category:  r.CommandType.String(),
+			Phase:     "X",
+			Timestamp: float64(r.Start-origin) / 1e3,
+			Duration:  float64(r.End-r.Start) / 1e3,
+			Process:   1,
+			Thread:    threads[r.CommandQueue],
+			Args:      args,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{events})
+}
+
+// Returns the summary for each command type and kernel, largest total time
+// first. Commands that terminated with an error are not included.
+func (p *Profiler) Summary() ([]ProfileSummary, error) {
+	records, err := p.Records()
+	return summarizeProfile(records), err
+}
+
+func summarizeProfile(records []ProfileRecord) []ProfileSummary {
+
+	type key struct {
+		commandType CommandType
+		kernel      string
+	}
+	durations := make(map[key][]time.Duration)
+	bytes := make(map[key]int64)
+	for _, r := range records {
+		if r.Err != nil {
+			continue
+		}
+		k := key{r.CommandType, r.Kernel}
+		durations[k] = append(durations[k], time.Duration(r.End-r.Start))
+		bytes[k] += r.Bytes
+	}
+
+	summaries := make([]ProfileSummary, 0, len(durations))
+	for k, d := range durations {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		s := ProfileSummary{CommandType: k.commandType, Kernel: k.kernel, Count: len(d), Bytes: bytes[k]}
+		for _, duration := range d {
+			s.Total += duration
+		}
+		s.Mean = s.Total / time.Duration(len(d))
+		s.P50 = percentile(d, 0.50)
+		s.P99 = percentile(d, 0.99)
+		summaries = append(summaries, s)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Total != summaries[j].Total {
+			return summaries[i].Total > summaries[j].Total
+		}
+		if summaries[i].CommandType != summaries[j].CommandType {
+			return summaries[i].CommandType < summaries[j].CommandType
+		}
+		return summaries[i].Kernel < summaries[j].Kernel
+	})
+
+	return summaries
+}
+
+// Returns the nearest rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// Writes the summary as a table.
+func (p *Profiler) WriteSummary(w io.Writer) error {
+
+	summaries, err := p.Summary()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "command\tkernel\tcount\tbytes\ttotal\tmean\tp50\tp99\t")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", s.CommandType, s.Kernel, s.Count, s.Bytes, s.Total,
+			s.Mean, s.P50, s.P99)
+	}
+	return tw.Flush()
+}
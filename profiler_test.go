@@ -0,0 +1,199 @@
+package cl11
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeProfile(t *testing.T) {
+	var records []ProfileRecord
+	for i := 1; i <= 100; i++ {
+		records = append(records, ProfileRecord{CommandType: CommandNDRangeKernel, Kernel: "a", Start: 0,
+			End: int64(i)})
+	}
+	records = append(records,
+		ProfileRecord{CommandType: CommandCopyBuffer, Bytes: 64, Start: 0, End: 10000},
+		ProfileRecord{CommandType: CommandCopyBuffer, Bytes: 64, Start: 0, End: 20000},
+		ProfileRecord{CommandType: CommandCopyBuffer, Err: InvalidValue})
+
+	summaries := summarizeProfile(records)
+	if len(summaries) != 2 {
+		t.Fatalf("got %d summaries, want 2", len(summaries))
+	}
+
+	copies := summaries[0]
+	if copies.CommandType != CommandCopyBuffer || copies.Count != 2 || copies.Bytes != 128 ||
+		copies.Total != 30000 || copies.Mean != 15000 || copies.P50 != 10000 || copies.P99 != 20000 {
+		t.Errorf("unexpected copy summary %+v", copies)
+	}
+
+	kernels := summaries[1]
+	if kernels.Kernel != "a" || kernels.Count != 100 || kernels.Total != 5050 || kernels.P50 != 50 ||
+		kernels.P99 != 99 {
+		t.Errorf("unexpected kernel summary %+v", kernels)
+	}
+}
+
+func TestProfilerEventCopy(t *testing.T) {
+	p := &Profiler{}
+	cq := &CommandQueue{Profiler: p}
+
+	// The caller reuses its event for another command.
+	e := &Event{CommandType: CommandNDRangeKernel}
+	cq.profileCommand(e, true, nil, "k", 0)
+	e.CommandType = CommandTask
+
+	if len(p.pending) != 1 {
+		t.Fatalf("got %d pending commands, want 1", len(p.pending))
+	}
+	if pending := p.pending[0].event; pending == e || pending.CommandType != CommandNDRangeKernel {
+		t.Error("profiler kept the caller's event")
+	}
+}
+
+func TestProfilerTrace(t *testing.T) {
+	cq := &CommandQueue{Device: &Device{Name: "test"}, Properties: QueueProfilingEnable}
+	p, err := NewProfiler(cq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.records = []ProfileRecord{
+		{CommandQueue: cq, CommandType: CommandNDRangeKernel, Kernel: "copy", Queued: 1000, Submit: 2000,
+			Start: 3000, End: 5000},
+		{CommandQueue: cq, CommandType: CommandCopyBuffer, Bytes: 16, Queued: 5000, Submit: 5000, Start: 6000,
+			End: 7000},
+	}
+
+	var b bytes.Buffer
+	err = p.WriteTrace(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	err = json.Unmarshal(b.Bytes(), &trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.TraceEvents) != 3 {
+		t.Fatalf("got %d trace events, want 3", len(trace.TraceEvents))
+	}
+	kernel := trace.TraceEvents[1]
+	if kernel.Name != "copy" || kernel.Phase != "X" || kernel.Timestamp != 2 || kernel.Duration != 2 ||
+		kernel.Thread != 1 {
+		t.Errorf("unexpected kernel trace event %+v", kernel)
+	}
+	if c := trace.TraceEvents[2]; c.Name != CommandCopyBuffer.String() || c.Args["bytes"] != 16.0 {
+		t.Errorf("unexpected copy trace event %+v", c)
+	}
+
+	b.Reset()
+	err = p.WriteSummary(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "copy") || !strings.Contains(b.String(), (2*time.Microsecond).String()) {
+		t.Errorf("unexpected summary\n%s", b.String())
+	}
+
+	_, err = NewProfiler(&CommandQueue{})
+	if err != ProfilingInfoNotAvailable {
+		t.Error("expected ProfilingInfoNotAvailable, got", err)
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 1024
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, QueueProfilingEnable)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+
+		profiler, err := NewProfiler(cq)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		buffer, err := ctx.CreateDeviceBuffer(int64(elements*4), MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, buffer)
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		err = kernel.SetArguments(buffer)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		for i := 0; i < 3; i++ {
+			err = cq.EnqueueNDRangeKernel(kernel, nil, []int{elements}, nil, nil, nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		err = cq.Finish()
+		if err != nil {
+			t.Error(err)
+		}
+
+		summaries, err := profiler.Summary()
+		if err != nil {
+			t.Error(err)
+		}
+		if len(summaries) != 1 || summaries[0].Kernel != "index" || summaries[0].Count != 3 {
+			t.Errorf("unexpected summaries %+v", summaries)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
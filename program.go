@@ -1,6 +1,7 @@
 package cl11
 
 import (
+	"bytes"
 	"strings"
 	"unsafe"
 
@@ -19,6 +20,9 @@ type Program struct {
 
 	// The build options specified during Build.
 	Options string
+
+	// The sources the program was created with, if any.
+	sources [][]byte
 }
 
 // A program binary for a device.
@@ -33,6 +37,10 @@ type ProgramBinary struct {
 	// The binary. Can be an implementation specific intermediate
 	// representation, device specific bits, or both.
 	Binary []byte
+
+	// Describes what the binary was built for and from, stored with the binary
+	// by MarshalBinary.
+	Info ProgramBinaryInfo
 }
 
 type BuildStatus clw.BuildStatus
@@ -75,6 +83,7 @@ func (c *Context) CreateProgramWithSource(sources ...[]byte) (*Program, error) {
 		id:      program,
 		Context: c,
 		Devices: c.Devices,
+		sources: sources,
 	}, nil
 }
 
@@ -113,7 +122,8 @@ func (c *Context) CreateProgramWithBinary(d []*Device, binaries [][]byte, status
 //
 // The callback is optional. If it is supplied Build will return immediately, if
 // it isn't then Build will block until the program has been build (successfully
-// or unsuccessfully).
+// or unsuccessfully). A failed blocking build returns a *ProgramBuildError with
+// the build logs.
 func (p *Program) Build(d []*Device, options string, pc ProgramCallback, userData interface{}) error {
 
 	devices := make([]clw.DeviceID, len(d))
@@ -130,7 +140,11 @@ func (p *Program) Build(d []*Device, options string, pc ProgramCallback, userDat
 
 	p.Options = options
 
-	return clw.BuildProgram(p.id, devices, options, callback, userData)
+	err := clw.BuildProgram(p.id, devices, options, callback, userData)
+	if err == BuildProgramFailure && pc == nil {
+		return p.buildError(d)
+	}
+	return err
 }
 
 // Returns the binaries for each device associated with the program.
@@ -158,6 +172,11 @@ func (p *Program) GetProgramBinaries() ([]ProgramBinary, error) {
 	binaries := make([][]byte, len(p.Devices))
 	binaryPointers := make([]unsafe.Pointer, len(p.Devices))
 	for i := range binaries {
+		// Devices the program has not been built for have no binary, the
+		// implementation skips their nil pointers.
+		if sizes[i] == 0 {
+			continue
+		}
 		binaries[i] = make([]byte, int(sizes[i]))
 		binaryPointers[i] = unsafe.Pointer(&binaries[i][0])
 	}
@@ -174,13 +193,13 @@ func (p *Program) GetProgramBinaries() ([]ProgramBinary, error) {
 		programBinary := &programBinaries[i]
 
 		programBinary.Program = p
+		programBinary.Binary = binaries[i]
 
-		for j := range p.Devices {
-			device := p.Devices[j]
-
-			if device.id == devices[j] {
+		// The implementation's device order may differ from the program's.
+		for _, device := range p.Devices {
+			if device.id == devices[i] {
 				programBinary.Device = device
-				programBinary.Binary = binaries[j]
+				programBinary.Info = newProgramBinaryInfo(p, device)
 				break
 			}
 		}
@@ -216,6 +235,162 @@ func (p *Program) BuildLog(d *Device) (string, error) {
 	return strings.TrimSpace(string(buffer[:len(buffer)-1])), nil
 }
 
+// Returns the program source as a single string, the concatenation of the
+// sources the program was created with. Programs created from binaries return
+// an empty string.
+func (p *Program) GetSource() (string, error) {
+	return p.getString(clw.ProgramSource)
+}
+
+// Returns the number of devices associated with the program.
+func (p *Program) GetNumDevices() (int, error) {
+	var param clw.Uint
+	err := clw.GetProgramInfo(p.id, clw.ProgramNumDevices, clw.Size(unsafe.Sizeof(param)), unsafe.Pointer(&param),
+		nil)
+	return int(param), err
+}
+
+// Returns the devices associated with the program as reported by the
+// implementation. Devices in the program's context are returned as is, any
+// others have their information queried.
+func (p *Program) GetDevices() ([]*Device, error) {
+
+	numDevices, err := p.GetNumDevices()
+	if err != nil {
+		return nil, err
+	}
+	if numDevices == 0 {
+		return nil, nil
+	}
+
+	ids := make([]clw.DeviceID, numDevices)
+	err = clw.GetProgramInfo(p.id, clw.ProgramDevices, clw.Size(unsafe.Sizeof(ids[0])*uintptr(len(ids))),
+		unsafe.Pointer(&ids[0]), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*Device, numDevices)
+	for i, id := range ids {
+		if p.Context != nil {
+			for _, d := range p.Context.Devices {
+				if d.id == id {
+					devices[i] = d
+					break
+				}
+			}
+		}
+		if devices[i] == nil {
+			d := &Device{id: id}
+			err = d.getAllInfo()
+			if err != nil {
+				return nil, err
+			}
+			devices[i] = d
+		}
+	}
+
+	return devices, nil
+}
+
+// Returns the context associated with the program. If it is the program's
+// Context that is returned, otherwise a context is created from the
+// implementation's information. No additional reference to the context is
+// retained.
+func (p *Program) GetContext() (*Context, error) {
+
+	var id clw.Context
+	err := clw.GetProgramInfo(p.id, clw.ProgramContext, clw.Size(unsafe.Sizeof(id)), unsafe.Pointer(&id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Context != nil && p.Context.id == id {
+		return p.Context, nil
+	}
+
+	return contextFromID(id)
+}
+
+// Returns the build options used for the last build of the program for the
+// device.
+func (p *Program) BuildOptions(d *Device) (string, error) {
+
+	var paramValueSize clw.Size
+	err := clw.GetProgramBuildInfo(p.id, d.id, clw.ProgramBuildOptions, 0, nil, &paramValueSize)
+	if err != nil {
+		return "", err
+	}
+	if paramValueSize == 0 {
+		return "", nil
+	}
+
+	buffer := make([]byte, paramValueSize)
+	err = clw.GetProgramBuildInfo(p.id, d.id, clw.ProgramBuildOptions, paramValueSize, unsafe.Pointer(&buffer[0]),
+		nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Trim space and trailing \0.
+	return strings.TrimSpace(string(buffer[:len(buffer)-1])), nil
+}
+
+// Returns the names of the kernel functions in the program without creating
+// kernel objects.
+//
+// OpenCL 1.1 has no query for kernel names so they are parsed from the program
+// source, see ParseKernelSignatures. Programs created from binaries have no
+// source and return ErrNoProgramSource.
+func (p *Program) KernelNames() ([]string, error) {
+
+	var source []byte
+	if p.sources != nil {
+		source = bytes.Join(p.sources, nil)
+	} else {
+		s, err := p.GetSource()
+		if err != nil {
+			return nil, err
+		}
+		source = []byte(s)
+	}
+	if len(bytes.TrimSpace(source)) == 0 {
+		return nil, ErrNoProgramSource
+	}
+
+	signatures, err := ParseKernelSignatures(source)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(signatures))
+	for i := range signatures {
+		names[i] = signatures[i].Name
+	}
+	return names, nil
+}
+
+func (p *Program) getString(paramName clw.ProgramInfo) (string, error) {
+
+	var paramValueSize clw.Size
+	err := clw.GetProgramInfo(p.id, paramName, 0, nil, &paramValueSize)
+	if err != nil {
+		return "", err
+	}
+	if paramValueSize == 0 {
+		return "", nil
+	}
+
+	buffer := make([]byte, paramValueSize)
+	err = clw.GetProgramInfo(p.id, paramName, paramValueSize, unsafe.Pointer(&buffer[0]), nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Trim trailing \0.
+	return string(buffer[:len(buffer)-1]), nil
+}
+
 // Increments the program reference count.
 //
 // The OpenCL commands that return a program perform an implicit retain.
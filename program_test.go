@@ -0,0 +1,129 @@
+package cl11
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBuildAsync(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		good, err := ctx.CreateProgramWithSource([]byte(kernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, good)
+
+		bad, err := ctx.CreateProgramWithSource([]byte("__kernel void bad(__global float* a) { a[0] = x; }\n"))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, bad)
+
+		goodResults := good.BuildAsync(context.Background(), []*Device{device}, "")
+		badResults := bad.BuildAsync(context.Background(), []*Device{device}, "")
+
+		result := <-goodResults
+		if result.Err != nil {
+			t.Error(result.Err)
+		}
+		if len(result.Logs) != 1 || result.Logs[0].Status != BuildSuccess {
+			t.Error("expected successful build log, got", result.Logs)
+		}
+
+		result = <-badResults
+		var buildErr *ProgramBuildError
+		if !errors.As(result.Err, &buildErr) || !errors.Is(result.Err, BuildProgramFailure) {
+			t.Error("expected ProgramBuildError, got", result.Err)
+		} else if len(buildErr.Diagnostics) == 0 {
+			t.Error("expected diagnostics in", buildErr.Logs)
+		}
+
+		if _, ok := <-goodResults; ok {
+			t.Error("results channel not closed")
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
+
+func TestProgramInfo(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		program, err := ctx.CreateProgramWithSource([]byte(kernel), []byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "-cl-mad-enable", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		source, err := program.GetSource()
+		if err != nil {
+			t.Error(err)
+		} else if source != kernel+indexKernel {
+			t.Error("source mismatch, got", source)
+		}
+
+		numDevices, err := program.GetNumDevices()
+		if err != nil || numDevices != 1 {
+			t.Error("expected 1 device, got", numDevices, err)
+		}
+
+		devices, err := program.GetDevices()
+		if err != nil || len(devices) != 1 || devices[0] != device {
+			t.Error("expected", device, "got", devices, err)
+		}
+
+		programCtx, err := program.GetContext()
+		if err != nil || programCtx != ctx {
+			t.Error("expected", ctx, "got", programCtx, err)
+		}
+
+		options, err := program.BuildOptions(device)
+		if err != nil || options != "-cl-mad-enable" {
+			t.Error("expected -cl-mad-enable, got", options, err)
+		}
+
+		names, err := program.KernelNames()
+		if err != nil || len(names) != 2 || names[0] != "copy" || names[1] != "index" {
+			t.Error("expected [copy index], got", names, err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
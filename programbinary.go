@@ -0,0 +1,270 @@
+package cl11
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Describes what a program binary was built for and from.
+type ProgramBinaryInfo struct {
+
+	// The device the binary was compiled for.
+	DeviceName   string
+	DeviceVendor string
+
+	// The device's DriverVersion and its platform's Version as strings.
+	DriverVersion   string
+	PlatformVersion string
+
+	// The build options the binary was built with.
+	Options string
+
+	// The hash of the sources the binary was built from, see SourceHash. All
+	// zeros if unknown.
+	SourceHash [sha256.Size]byte
+}
+
+// Program binary container format version, incremented on incompatible changes.
+const programBinaryVersion = 1
+
+var (
+	programBinaryMagic = []byte("CL11BIN\x00")
+	programBundleMagic = []byte("CL11BDL\x00")
+	crcTable           = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Returns the hash identifying a set of sources passed to
+// CreateProgramWithSource.
+func SourceHash(sources ...[]byte) [sha256.Size]byte {
+	return sourceHash(sources)
+}
+
+func sourceHash(sources [][]byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, source := range sources {
+		sum := sha256.Sum256(source)
+		h.Write(sum[:])
+	}
+	var result [sha256.Size]byte
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+func newProgramBinaryInfo(p *Program, d *Device) ProgramBinaryInfo {
+	info := ProgramBinaryInfo{
+		DeviceName:    d.Name,
+		DeviceVendor:  d.Vendor,
+		DriverVersion: d.DriverVersion.String(),
+		Options:       p.Options,
+	}
+	if d.Platform != nil {
+		info.PlatformVersion = d.Platform.Version.String()
+	}
+	if p.sources != nil {
+		info.SourceHash = sourceHash(p.sources)
+	}
+	return info
+}
+
+// True if the binary was built for the device with its current driver.
+func (info *ProgramBinaryInfo) Matches(d *Device) bool {
+	var platformVersion string
+	if d.Platform != nil {
+		platformVersion = d.Platform.Version.String()
+	}
+	return info.DeviceName == d.Name && info.DeviceVendor == d.Vendor &&
+		info.DriverVersion == d.DriverVersion.String() && info.PlatformVersion == platformVersion
+}
+
+// Encodes the binary and its Info in a versioned container with a checksum.
+//
+// The container is a magic string, the format version, the Info fields, the
+// binary and a CRC-32C of everything before it. Integers are little endian and
+// strings and the binary are prefixed by their length.
+func (pb *ProgramBinary) MarshalBinary() ([]byte, error) {
+
+	var b bytes.Buffer
+	b.Write(programBinaryMagic)
+	binary.Write(&b, binary.LittleEndian, uint16(programBinaryVersion))
+	for _, s := range []string{pb.Info.DeviceName, pb.Info.DeviceVendor, pb.Info.DriverVersion,
+		pb.Info.PlatformVersion, pb.Info.Options} {
+		writeBytes(&b, []byte(s))
+	}
+	b.Write(pb.Info.SourceHash[:])
+	writeBytes(&b, pb.Binary)
+	binary.Write(&b, binary.LittleEndian, crc32.Checksum(b.Bytes(), crcTable))
+
+	return b.Bytes(), nil
+}
+
+// Decodes a container created by MarshalBinary, setting Binary and Info.
+// Program and Device are not set.
+func (pb *ProgramBinary) UnmarshalBinary(data []byte) error {
+
+	if len(data) < len(programBinaryMagic)+2+4 || !bytes.HasPrefix(data, programBinaryMagic) {
+		return ErrInvalidProgramBinary
+	}
+
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(sum) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidProgramBinary)
+	}
+
+	r := bytes.NewReader(body[len(programBinaryMagic):])
+	var version uint16
+	binary.Read(r, binary.LittleEndian, &version)
+	if version != programBinaryVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProgramBinary, version)
+	}
+
+	var info ProgramBinaryInfo
+	for _, s := range []*string{&info.DeviceName, &info.DeviceVendor, &info.DriverVersion, &info.PlatformVersion,
+		&info.Options} {
+		field, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		*s = string(field)
+	}
+	if _, err := io.ReadFull(r, info.SourceHash[:]); err != nil {
+		return errTruncatedProgramBinary
+	}
+	binaryData, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return ErrInvalidProgramBinary
+	}
+
+	pb.Info = info
+	pb.Binary = binaryData
+	return nil
+}
+
+// Encodes several binaries, e.g. one per supported device, into one bundle.
+func MarshalProgramBundle(binaries []ProgramBinary) ([]byte, error) {
+
+	var b bytes.Buffer
+	b.Write(programBundleMagic)
+	binary.Write(&b, binary.LittleEndian, uint32(len(binaries)))
+	for i := range binaries {
+		data, err := binaries[i].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		writeBytes(&b, data)
+	}
+
+	return b.Bytes(), nil
+}
+
+// Decodes a bundle created by MarshalProgramBundle.
+func UnmarshalProgramBundle(data []byte) ([]ProgramBinary, error) {
+
+	if !bytes.HasPrefix(data, programBundleMagic) {
+		return nil, ErrInvalidProgramBinary
+	}
+
+	r := bytes.NewReader(data[len(programBundleMagic):])
+	var count uint32
+	err := binary.Read(r, binary.LittleEndian, &count)
+	if err != nil || int64(count) > int64(r.Len()) {
+		return nil, errTruncatedProgramBinary
+	}
+
+	binaries := make([]ProgramBinary, count)
+	for i := range binaries {
+		data, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		err = binaries[i].UnmarshalBinary(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidProgramBinary
+	}
+
+	return binaries, nil
+}
+
+// Creates a program for the devices from the matching binaries in a bundle.
+//
+// For each device a binary built for it with its current driver and platform
+// version and with options is selected. If sources are given the binary must
+// also have been built from them. Stale binaries are never passed to the
+// implementation, ErrNoMatchingBinary is returned if a device has no matching
+// binary. As with CreateProgramWithBinary the program must still be built.
+func (c *Context) CreateProgramFromBundle(d []*Device, bundle []ProgramBinary, options string,
+	sources ...[]byte) (*Program, error) {
+
+	binaries, err := selectProgramBinaries(d, bundle, options, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := c.CreateProgramWithBinary(d, binaries, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) > 0 {
+		p.sources = sources
+	}
+
+	return p, nil
+}
+
+func selectProgramBinaries(d []*Device, bundle []ProgramBinary, options string,
+	sources [][]byte) ([][]byte, error) {
+
+	var hash [sha256.Size]byte
+	if len(sources) > 0 {
+		hash = sourceHash(sources)
+	}
+
+	binaries := make([][]byte, len(d))
+	for i := range d {
+		for j := range bundle {
+			info := &bundle[j].Info
+			if info.Matches(d[i]) && info.Options == options && (len(sources) == 0 || info.SourceHash == hash) {
+				binaries[i] = bundle[j].Binary
+				break
+			}
+		}
+		if binaries[i] == nil {
+			return nil, fmt.Errorf("%w for %s", ErrNoMatchingBinary, d[i].Name)
+		}
+	}
+
+	return binaries, nil
+}
+
+func writeBytes(b *bytes.Buffer, data []byte) {
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+// Reads a field written by writeBytes.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	err := binary.Read(r, binary.LittleEndian, &n)
+	if err != nil || int64(n) > int64(r.Len()) {
+		return nil, errTruncatedProgramBinary
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, errTruncatedProgramBinary
+	}
+	return data, nil
+}
+
+// Returned when the data ends before a field does, it is both an
+// ErrInvalidProgramBinary and an io.ErrUnexpectedEOF.
+var errTruncatedProgramBinary = fmt.Errorf("%w: %w", ErrInvalidProgramBinary, io.ErrUnexpectedEOF)
@@ -0,0 +1,128 @@
+package cl11
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestProgramBinaryMarshal(t *testing.T) {
+	pb := ProgramBinary{
+		Binary: []byte("binary"),
+		Info: ProgramBinaryInfo{
+			DeviceName:      "test",
+			DeviceVendor:    "vendor",
+			DriverVersion:   "1.0",
+			PlatformVersion: "OpenCL 1.1",
+			Options:         "-cl-mad-enable",
+			SourceHash:      SourceHash([]byte(kernel)),
+		},
+	}
+
+	data, err := pb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded ProgramBinary
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Binary, pb.Binary) || decoded.Info != pb.Info {
+		t.Errorf("got %+v, want %+v", decoded, pb)
+	}
+
+	for i := range data {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0xff
+		if err := decoded.UnmarshalBinary(corrupt); !errors.Is(err, ErrInvalidProgramBinary) {
+			t.Errorf("corruption at byte %d not detected, got %v", i, err)
+		}
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrInvalidProgramBinary) {
+		t.Error("truncation not detected, got", err)
+	}
+}
+
+func TestProgramBundle(t *testing.T) {
+	d := &Device{Name: "test", Vendor: "vendor", DriverVersion: Version{Major: 1, Minor: 1}}
+	sources := [][]byte{[]byte(kernel)}
+
+	current := ProgramBinaryInfo{DeviceName: "test", DeviceVendor: "vendor",
+		DriverVersion: d.DriverVersion.String(), SourceHash: sourceHash(sources)}
+	stale := current
+	stale.DriverVersion = Version{Major: 1, Minor: 0}.String()
+	other := current
+	other.DeviceName = "other"
+
+	bundle := []ProgramBinary{
+		{Binary: []byte("stale"), Info: stale},
+		{Binary: []byte("other"), Info: other},
+		{Binary: []byte("current"), Info: current},
+	}
+	data, err := MarshalProgramBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err = UnmarshalProgramBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle) != 3 {
+		t.Fatalf("got %d binaries, want 3", len(bundle))
+	}
+
+	binaries, err := selectProgramBinaries([]*Device{d}, bundle, "", sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(binaries[0]) != "current" {
+		t.Errorf("selected %q, want %q", binaries[0], "current")
+	}
+
+	_, err = selectProgramBinaries([]*Device{d}, bundle, "-cl-mad-enable", sources)
+	if !errors.Is(err, ErrNoMatchingBinary) {
+		t.Error("binary with different options selected")
+	}
+	_, err = selectProgramBinaries([]*Device{d}, bundle, "", [][]byte{[]byte(indexKernel)})
+	if !errors.Is(err, ErrNoMatchingBinary) {
+		t.Error("binary with different source selected")
+	}
+	_, err = selectProgramBinaries([]*Device{d}, bundle[:2], "", nil)
+	if !errors.Is(err, ErrNoMatchingBinary) {
+		t.Error("stale binary selected")
+	}
+}
+
+func TestProgramBinaryTruncated(t *testing.T) {
+	pb := ProgramBinary{Binary: []byte("binary"), Info: ProgramBinaryInfo{DeviceName: "test"}}
+	data, err := pb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the end of the binary but keep the checksum valid, so only the
+	// length prefix reveals the truncation.
+	body := data[:len(data)-4-3]
+	truncated := binary.LittleEndian.AppendUint32(append([]byte(nil), body...), crc32.Checksum(body, crcTable))
+	var got ProgramBinary
+	err = got.UnmarshalBinary(truncated)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrInvalidProgramBinary) {
+		t.Error("expected a truncated program binary error, got", err)
+	}
+
+	bundle, err := MarshalProgramBundle([]ProgramBinary{pb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{len(bundle) - 1, len(programBundleMagic) + 2} {
+		_, err = UnmarshalProgramBundle(bundle[:n])
+		if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrInvalidProgramBinary) {
+			t.Error(n, "expected a truncated bundle error, got", err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package cl11
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A ProgramCache stores program binaries on disk to avoid compiling the same
+// source at every process start.
+//
+// Binaries are keyed by a hash of the sources, the build options, the device
+// name, the device driver version and the platform version, so a driver update
+// invalidates them. Files are written atomically so several processes can
+// share a cache directory.
+type ProgramCache struct {
+
+	// The directory binaries are stored in. It is created if required.
+	Dir string
+
+	// The maximum total size in bytes of the cached binaries, the least
+	// recently used binaries are removed once it is exceeded. Zero means no
+	// limit.
+	MaxSize int64
+}
+
+const programCacheExt = ".clbin"
+
+// Returns a program built for the devices from the sources with options.
+//
+// If binaries for every device are in the cache the program is created from
+// them, otherwise (or if the implementation rejects the binaries) it is built
+// from source and the resulting binaries are stored.
+func (pc *ProgramCache) Program(c *Context, d []*Device, options string, sources ...[]byte) (*Program, error) {
+
+	keys := make([]string, len(d))
+	for i := range d {
+		keys[i] = programCacheKey(sources, options, d[i])
+	}
+
+	p, err := pc.fromBinaries(c, d, options, sources, keys)
+	if err == nil {
+		return p, nil
+	}
+
+	p, err = c.CreateProgramWithSource(sources...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.Build(d, options, nil, nil)
+	if err != nil {
+		p.Release()
+		return nil, err
+	}
+
+	// Failing to cache the binaries is not fatal, the program is usable.
+	pc.store(p, d, keys)
+
+	return p, nil
+}
+
+// Creates and builds a program from cached binaries. Any rejected binaries are
+// removed from the cache.
+func (pc *ProgramCache) fromBinaries(c *Context, d []*Device, options string, sources [][]byte,
+	keys []string) (*Program, error) {
+
+	binaries := make([][]byte, len(d))
+	for i := range keys {
+		binary, err := ioutil.ReadFile(pc.path(keys[i]))
+		if err != nil {
+			return nil, err
+		}
+		binaries[i] = binary
+	}
+
+	status := make([]error, len(d))
+	p, err := c.CreateProgramWithBinary(d, binaries, status)
+	for i := range status {
+		if status[i] != nil || err == InvalidBinary {
+			os.Remove(pc.path(keys[i]))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range status {
+		if status[i] != nil {
+			p.Release()
+			return nil, status[i]
+		}
+	}
+
+	err = p.Build(d, options, nil, nil)
+	if err != nil {
+		for i := range keys {
+			os.Remove(pc.path(keys[i]))
+		}
+		p.Release()
+		return nil, err
+	}
+	p.sources = sources
+
+	// Mark the binaries as recently used.
+	now := time.Now()
+	for i := range keys {
+		os.Chtimes(pc.path(keys[i]), now, now)
+	}
+
+	return p, nil
+}
+
+// Writes the program's binaries for the devices to the cache and evicts old
+// binaries if the cache is too large.
+func (pc *ProgramCache) store(p *Program, d []*Device, keys []string) error {
+
+	binaries, err := p.GetProgramBinaries()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(pc.Dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	for i := range d {
+		for j := range binaries {
+			if binaries[j].Device != d[i] || len(binaries[j].Binary) == 0 {
+				continue
+			}
+			err = writeFileAtomic(pc.path(keys[i]), binaries[j].Binary)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return pc.evict()
+}
+
+// Removes the least recently used binaries until the cache fits in MaxSize.
+func (pc *ProgramCache) evict() error {
+
+	if pc.MaxSize <= 0 {
+		return nil
+	}
+
+	infos, err := ioutil.ReadDir(pc.Dir)
+	if err != nil {
+		return err
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, info := range infos {
+		if info.Mode().IsRegular() && filepath.Ext(info.Name()) == programCacheExt {
+			files = append(files, info)
+			total += info.Size()
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		if total <= pc.MaxSize {
+			break
+		}
+		err = os.Remove(filepath.Join(pc.Dir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+// Removes every binary from the cache.
+func (pc *ProgramCache) Clear() error {
+	matches, err := filepath.Glob(filepath.Join(pc.Dir, "*"+programCacheExt))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		err = os.Remove(match)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pc *ProgramCache) path(key string) string {
+	return filepath.Join(pc.Dir, key+programCacheExt)
+}
+
+func programCacheKey(sources [][]byte, options string, d *Device) string {
+
+	var platformVersion string
+	if d.Platform != nil {
+		platformVersion = d.Platform.Version.String()
+	}
+
+	h := sha256.New()
+	hash := sourceHash(sources)
+	h.Write(hash[:])
+	for _, field := range []string{options, d.Name, d.DriverVersion.String(), platformVersion} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
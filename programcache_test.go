@@ -0,0 +1,128 @@
+package cl11
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProgramCacheKey(t *testing.T) {
+	d := &Device{Name: "test", DriverVersion: Version{Major: 1, Minor: 0}}
+	sources := [][]byte{[]byte(kernel)}
+
+	key := programCacheKey(sources, "", d)
+	if key != programCacheKey(sources, "", d) {
+		t.Error("key is not deterministic")
+	}
+	if key == programCacheKey(sources, "-cl-fast-relaxed-math", d) {
+		t.Error("key does not depend on options")
+	}
+	if key == programCacheKey([][]byte{[]byte(indexKernel)}, "", d) {
+		t.Error("key does not depend on source")
+	}
+	updated := &Device{Name: "test", DriverVersion: Version{Major: 1, Minor: 1}}
+	if key == programCacheKey(sources, "", updated) {
+		t.Error("key does not depend on driver version")
+	}
+}
+
+func TestProgramCacheEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cl11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pc := &ProgramCache{Dir: dir, MaxSize: 250}
+	now := time.Now()
+	for i, name := range []string{"a", "b", "c"} {
+		path := pc.path(name)
+		err = ioutil.WriteFile(path, make([]byte, 100), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	err = pc.evict()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(pc.path("a")); !os.IsNotExist(err) {
+		t.Error("least recently used binary not evicted")
+	}
+	for _, name := range []string{"b", "c"} {
+		if _, err := os.Stat(pc.path(name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = pc.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(matches) != 0 {
+		t.Error("expected empty cache, got", matches)
+	}
+}
+
+func TestProgramCacheSubset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cl11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	allPlatforms, err := GetPlatforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, platform := range allPlatforms {
+		t.Log(len(platform.Devices), "devices on", platform.Name)
+
+		var toRelease []Object
+
+		ctx, err := CreateContext(platform.Devices, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		// Only the last device, so any others have no binary.
+		device := platform.Devices[len(platform.Devices)-1]
+		pc := &ProgramCache{Dir: dir}
+		program, err := pc.Program(ctx, []*Device{device}, "", []byte(kernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		binaries, err := program.GetProgramBinaries()
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		for _, binary := range binaries {
+			if binary.Device == nil {
+				t.Error("binary without a device")
+			} else if (binary.Device == device) != (len(binary.Binary) > 0) {
+				t.Error("unexpected binary size", len(binary.Binary), "for", binary.Device.Name)
+			}
+		}
+
+		if _, err := os.Stat(pc.path(programCacheKey([][]byte{[]byte(kernel)}, "", device))); err != nil {
+			t.Error(err)
+		}
+
+		releaseAll(toRelease, t)
+	}
+}
@@ -0,0 +1,192 @@
+package cl11
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// A ProgramTemplate builds specialised variants of a kernel source written as a
+// text/template, e.g. for different tile sizes or data types.
+//
+// Each variant is rendered with a parameter value, built once per parameter
+// set and device set, and cached. Concurrent requests for a variant that is
+// being built wait for that build rather than starting another.
+type ProgramTemplate struct {
+
+	// The context variants are created in.
+	Context *Context
+
+	// The build options used for every variant.
+	Options string
+
+	// An optional cache for the built binaries of each variant.
+	Cache *ProgramCache
+
+	template *template.Template
+
+	mu       sync.Mutex
+	variants map[string]*ProgramVariant
+}
+
+// A program built from a ProgramTemplate for a parameter set and device set.
+type ProgramVariant struct {
+
+	// The parameters the source was rendered with.
+	Params interface{}
+
+	// The rendered source.
+	Source []byte
+
+	// The built program.
+	Program *Program
+
+	// The kernels in the program by function name. Kernels are not safe for
+	// concurrent use, see KernelPool.
+	Kernels map[string]*Kernel
+
+	done chan struct{}
+	err  error
+}
+
+// Parses source as a text/template to create a ProgramTemplate for the
+// context.
+func (c *Context) CreateProgramTemplate(source, options string) (*ProgramTemplate, error) {
+
+	t, err := template.New("program").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProgramTemplate{
+		Context:  c,
+		Options:  options,
+		template: t,
+		variants: make(map[string]*ProgramVariant),
+	}, nil
+}
+
+// Returns the variant of the program rendered with params and built for the
+// devices, building it if required. If d is nil the variant is built for all
+// the context's devices.
+//
+// Variants are identified by the devices and the printed (%#v) value of
+// params, so params should be a value, such as a struct of plain fields,
+// rather than contain pointers. Failed builds are not cached.
+func (pt *ProgramTemplate) Variant(d []*Device, params interface{}) (*ProgramVariant, error) {
+
+	if d == nil {
+		d = pt.Context.Devices
+	}
+	key := programVariantKey(d, params)
+
+	pt.mu.Lock()
+	if v, ok := pt.variants[key]; ok {
+		pt.mu.Unlock()
+		<-v.done
+		if v.err != nil {
+			return nil, v.err
+		}
+		return v, nil
+	}
+	v := &ProgramVariant{Params: params, done: make(chan struct{})}
+	pt.variants[key] = v
+	pt.mu.Unlock()
+
+	v.err = pt.build(v, d)
+	if v.err != nil {
+		pt.mu.Lock()
+		delete(pt.variants, key)
+		pt.mu.Unlock()
+	}
+	close(v.done)
+
+	if v.err != nil {
+		return nil, v.err
+	}
+	return v, nil
+}
+
+// Renders the template with the variant's parameters and builds it.
+func (pt *ProgramTemplate) build(v *ProgramVariant, d []*Device) error {
+
+	var source bytes.Buffer
+	err := pt.template.Execute(&source, v.Params)
+	if err != nil {
+		return err
+	}
+	v.Source = source.Bytes()
+
+	var p *Program
+	if pt.Cache != nil {
+		p, err = pt.Cache.Program(pt.Context, d, pt.Options, v.Source)
+	} else {
+		p, err = pt.Context.CreateProgramWithSource(v.Source)
+		if err == nil {
+			err = p.Build(d, pt.Options, nil, nil)
+			if err != nil {
+				p.Release()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	kernels, err := p.CreateKernelsInProgram()
+	if err != nil {
+		p.Release()
+		return err
+	}
+
+	v.Program = p
+	v.Kernels = make(map[string]*Kernel, len(kernels))
+	for _, k := range kernels {
+		v.Kernels[k.FunctionName] = k
+	}
+
+	return nil
+}
+
+// Returns the variant's kernel with the function name.
+func (v *ProgramVariant) Kernel(name string) (*Kernel, error) {
+	k, ok := v.Kernels[name]
+	if !ok {
+		return nil, fmt.Errorf("cl: ProgramVariant: no kernel %q", name)
+	}
+	return k, nil
+}
+
+// Releases the kernels and programs of all built variants. The template can
+// continue to be used, variants are rebuilt when next requested.
+func (pt *ProgramTemplate) Release() error {
+
+	pt.mu.Lock()
+	variants := pt.variants
+	pt.variants = make(map[string]*ProgramVariant)
+	pt.mu.Unlock()
+
+	var err error
+	for _, v := range variants {
+		<-v.done
+		if v.err != nil {
+			continue
+		}
+		if releaseErr := releaseProgramAndKernels(v.Program, v.Kernels); err == nil {
+			err = releaseErr
+		}
+	}
+
+	return err
+}
+
+func programVariantKey(d []*Device, params interface{}) string {
+	var key strings.Builder
+	for _, device := range d {
+		fmt.Fprintf(&key, "%p,", device)
+	}
+	fmt.Fprintf(&key, "%T %#v", params, params)
+	return key.String()
+}
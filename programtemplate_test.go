@@ -0,0 +1,112 @@
+package cl11
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+var templateKernel = `
+__kernel void fill(__global {{.Type}}* out)
+{
+	out[get_global_id(0)] = ({{.Type}}){{.Value}};
+}
+`
+
+type fillParams struct {
+	Type  string
+	Value int
+}
+
+func TestProgramVariantKey(t *testing.T) {
+	a, b := &Device{}, &Device{}
+
+	key := programVariantKey([]*Device{a}, fillParams{"float", 1})
+	if key != programVariantKey([]*Device{a}, fillParams{"float", 1}) {
+		t.Error("key is not deterministic")
+	}
+	if key == programVariantKey([]*Device{a}, fillParams{"float", 2}) {
+		t.Error("key does not depend on params")
+	}
+	if key == programVariantKey([]*Device{b}, fillParams{"float", 1}) {
+		t.Error("key does not depend on devices")
+	}
+	if programVariantKey(nil, 1) == programVariantKey(nil, "1") {
+		t.Error("key does not depend on params type")
+	}
+}
+
+func TestProgramVariantFailedWait(t *testing.T) {
+	d := []*Device{{}}
+	params := fillParams{"float", 1}
+
+	// A variant another goroutine failed to build.
+	failed := &ProgramVariant{Params: params, done: make(chan struct{}), err: errors.New("build failed")}
+	close(failed.done)
+	pt := &ProgramTemplate{variants: map[string]*ProgramVariant{programVariantKey(d, params): failed}}
+
+	v, err := pt.Variant(d, params)
+	if v != nil || err != failed.err {
+		t.Error("expected nil and the build error, got", v, err)
+	}
+}
+
+func TestProgramTemplate(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		pt, err := ctx.CreateProgramTemplate(templateKernel, "")
+		if err != nil {
+			t.Error(err)
+			releaseAll([]Object{ctx}, t)
+			continue
+		}
+
+		var wg sync.WaitGroup
+		variants := make([]*ProgramVariant, 8)
+		for i := range variants {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v, err := pt.Variant(nil, fillParams{"int", 7})
+				if err != nil {
+					t.Error(err)
+				}
+				variants[i] = v
+			}(i)
+		}
+		wg.Wait()
+		for i := range variants {
+			if variants[i] != variants[0] {
+				t.Error("variant built more than once")
+			}
+		}
+
+		other, err := pt.Variant(nil, fillParams{"float", 7})
+		if err != nil {
+			t.Error(err)
+		} else if other == variants[0] {
+			t.Error("different params returned the same variant")
+		} else if _, err := other.Kernel("fill"); err != nil {
+			t.Error(err)
+		}
+
+		_, err = pt.Variant(nil, fillParams{"undefined_type", 7})
+		if err == nil {
+			t.Error("expected build error")
+		}
+
+		err = pt.Release()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll([]Object{ctx}, t)
+	}
+}
@@ -0,0 +1,306 @@
+package cl11
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// A ReloadingProgram is a program created from files that is rebuilt when the
+// files change, intended to shorten the kernel edit and run loop during
+// development.
+//
+// The files are polled for modification time changes. When one changes the
+// program is rebuilt in the background and, if the build succeeds, the new
+// program and kernels replace the old ones for subsequent calls to Program and
+// Kernel. A failed build is reported through Err and OnReload and the previous
+// program stays in use.
+//
+// Program retains what it returns and Kernel returns a new instance of the
+// kernel on every call, so a reload does not release a program or kernel that
+// is still in use. Callers should get the kernel and set its arguments for
+// every launch, and release it afterwards, to pick up the latest build.
+type ReloadingProgram struct {
+
+	// The context and devices the program is built for.
+	Context *Context
+	Devices []*Device
+
+	// The file system and entry file, see CreateProgramFromFS.
+	FS    fs.FS
+	Entry string
+
+	// Called after each rebuild attempt caused by a change with the build
+	// error, or nil if the new program was swapped in. It is called from the
+	// polling goroutine and must be set before the first change.
+	OnReload func(err error)
+
+	options *BuildOptions
+
+	// Serializes checks from the polling goroutine and Check.
+	checkMu sync.Mutex
+
+	mu      sync.RWMutex
+	program *Program
+	kernels map[string]*Kernel
+	err     error
+
+	// The modification times of the files read by the last build.
+	modTimes map[string]time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Creates and builds a program from the entry file in a file system, such as
+// os.DirFS, and rebuilds it whenever the entry file or a file it includes
+// changes. The files are checked every interval until Close is called.
+//
+// Includes are expanded as by CreateProgramFromFS and the program is built with
+// the remaining options, opts may be nil. An error is returned if the initial
+// build fails or the interval is not positive.
+func (c *Context) CreateReloadingProgram(d []*Device, fsys fs.FS, entry string, opts *BuildOptions,
+	interval time.Duration) (*ReloadingProgram, error) {
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("cl: CreateReloadingProgram: invalid interval %s", interval)
+	}
+
+	if d == nil {
+		d = c.Devices
+	}
+
+	rp := &ReloadingProgram{
+		Context: c,
+		Devices: d,
+		FS:      fsys,
+		Entry:   entry,
+		options: opts,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	err := rp.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	go rp.poll(interval)
+
+	return rp, nil
+}
+
+// Returns the current program, which has been retained and must be released by
+// the caller.
+func (rp *ReloadingProgram) Program() (*Program, error) {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	if rp.program == nil {
+		return nil, fmt.Errorf("cl: ReloadingProgram: closed")
+	}
+	err := rp.program.Retain()
+	if err != nil {
+		return nil, err
+	}
+	return rp.program, nil
+}
+
+// Returns a new instance of the current program's kernel with the function
+// name, which must be released by the caller. Since every call returns its own
+// instance, goroutines can set its arguments without synchronizing.
+func (rp *ReloadingProgram) Kernel(name string) (*Kernel, error) {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	k, ok := rp.kernels[name]
+	if !ok {
+		return nil, fmt.Errorf("cl: ReloadingProgram: no kernel %q", name)
+	}
+	return k.Clone()
+}
+
+// Returns the error of the most recent rebuild, or nil if it succeeded.
+func (rp *ReloadingProgram) Err() error {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return rp.err
+}
+
+// Checks the files for changes now rather than waiting for the next poll and
+// rebuilds if required. Reports whether a rebuild was attempted and its error.
+func (rp *ReloadingProgram) Check() (bool, error) {
+
+	rp.checkMu.Lock()
+	defer rp.checkMu.Unlock()
+
+	if !rp.changed() {
+		return false, nil
+	}
+
+	err := rp.reload()
+	if rp.OnReload != nil {
+		rp.OnReload(err)
+	}
+	return true, err
+}
+
+// Stops watching the files and releases the reloading program's references to
+// the current program and kernels. Calling Close again has no effect.
+func (rp *ReloadingProgram) Close() error {
+
+	var err error
+	rp.closeOnce.Do(func() {
+		close(rp.stop)
+		<-rp.done
+
+		rp.mu.Lock()
+		defer rp.mu.Unlock()
+		err = releaseProgramAndKernels(rp.program, rp.kernels)
+		rp.program, rp.kernels = nil, nil
+	})
+	return err
+}
+
+func (rp *ReloadingProgram) poll(interval time.Duration) {
+
+	defer close(rp.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rp.stop:
+			return
+		case <-ticker.C:
+			rp.Check()
+		}
+	}
+}
+
+// Reports whether any of the files read by the last build has been modified,
+// created or removed.
+func (rp *ReloadingProgram) changed() bool {
+
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	for name, modTime := range rp.modTimes {
+		info, err := fs.Stat(rp.FS, name)
+		if err != nil {
+			if !modTime.IsZero() {
+				return true
+			}
+			continue
+		}
+		if !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuilds the program and, if successful, swaps it in.
+func (rp *ReloadingProgram) reload() error {
+
+	source, files, err := expandIncludes(rp.FS, rp.Entry, rp.options)
+	if files == nil {
+		// Watch the entry file so fixing the error triggers another build.
+		files = []string{rp.Entry}
+	}
+
+	// Record the times before building so changes during the build are seen.
+	modTimes := make(map[string]time.Time, len(files))
+	for _, name := range files {
+		if info, statErr := fs.Stat(rp.FS, name); statErr == nil {
+			modTimes[name] = info.ModTime()
+		} else {
+			modTimes[name] = time.Time{}
+		}
+	}
+
+	var p *Program
+	var kernels map[string]*Kernel
+	if err == nil {
+		p, kernels, err = rp.build(source)
+	}
+
+	rp.mu.Lock()
+	if err == nil || rp.modTimes == nil {
+		rp.modTimes = modTimes
+	} else {
+		// Keep watching the files of the program in use as well as those of
+		// the failed build.
+		for name, modTime := range modTimes {
+			rp.modTimes[name] = modTime
+		}
+	}
+	rp.err = err
+	var oldProgram *Program
+	var oldKernels map[string]*Kernel
+	if err == nil {
+		oldProgram, oldKernels = rp.program, rp.kernels
+		rp.program, rp.kernels = p, kernels
+	}
+	rp.mu.Unlock()
+
+	// Callers that still use the old program or kernels hold their own
+	// references.
+	if oldProgram != nil {
+		releaseProgramAndKernels(oldProgram, oldKernels)
+	}
+
+	return err
+}
+
+func (rp *ReloadingProgram) build(source []byte) (*Program, map[string]*Kernel, error) {
+
+	// Includes have already been expanded.
+	var options string
+	if rp.options != nil {
+		opts := *rp.options
+		opts.IncludeDirs = nil
+		options = opts.String()
+	}
+
+	p, err := rp.Context.CreateProgramWithSource(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = p.Build(rp.Devices, options, nil, nil)
+	if err != nil {
+		p.Release()
+		return nil, nil, err
+	}
+
+	kernels, err := p.CreateKernelsInProgram()
+	if err != nil {
+		p.Release()
+		return nil, nil, err
+	}
+
+	kernelMap := make(map[string]*Kernel, len(kernels))
+	for _, k := range kernels {
+		kernelMap[k.FunctionName] = k
+	}
+
+	return p, kernelMap, nil
+}
+
+func releaseProgramAndKernels(p *Program, kernels map[string]*Kernel) error {
+
+	var err error
+	for _, k := range kernels {
+		if releaseErr := k.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	if p != nil {
+		if releaseErr := p.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
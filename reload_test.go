@@ -0,0 +1,197 @@
+package cl11
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestReloadingProgramChanged(t *testing.T) {
+	start := time.Unix(1000, 0)
+	fsys := fstest.MapFS{
+		"main.cl":   {Data: []byte(`#include "common.h"`), ModTime: start},
+		"common.h":  {Data: []byte(""), ModTime: start},
+		"unused.cl": {Data: []byte(""), ModTime: start},
+	}
+	rp := &ReloadingProgram{FS: fsys, modTimes: map[string]time.Time{"main.cl": start, "common.h": start}}
+
+	if rp.changed() {
+		t.Error("unmodified files reported as changed")
+	}
+
+	fsys["unused.cl"].ModTime = start.Add(time.Second)
+	if rp.changed() {
+		t.Error("unwatched file reported as changed")
+	}
+
+	fsys["common.h"].ModTime = start.Add(time.Second)
+	if !rp.changed() {
+		t.Error("modified include not reported as changed")
+	}
+
+	fsys["common.h"].ModTime = start
+	delete(fsys, "common.h")
+	if !rp.changed() {
+		t.Error("removed include not reported as changed")
+	}
+
+	rp.modTimes["common.h"] = time.Time{}
+	if rp.changed() {
+		t.Error("missing file reported as changed")
+	}
+	fsys["common.h"] = &fstest.MapFile{ModTime: start}
+	if !rp.changed() {
+		t.Error("created file not reported as changed")
+	}
+}
+
+func TestReloadingProgramInterval(t *testing.T) {
+	_, err := (&Context{}).CreateReloadingProgram(nil, fstest.MapFS{}, "main.cl", nil, 0)
+	if err == nil {
+		t.Error("expected error for zero interval")
+	}
+}
+
+func TestReloadingProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cl11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entry := filepath.Join(dir, "main.cl")
+	write := func(source string, modTime time.Time) {
+		err := ioutil.WriteFile(entry, []byte(source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(entry, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		start := time.Now().Add(-time.Hour)
+		write(kernel, start)
+		rp, err := ctx.CreateReloadingProgram(nil, os.DirFS(dir), "main.cl", nil, time.Hour)
+		if err != nil {
+			t.Error(err)
+			releaseAll([]Object{ctx}, t)
+			continue
+		}
+		toRelease := []Object{ctx}
+
+		original, err := rp.Program()
+		if err != nil {
+			t.Error(err)
+			rp.Close()
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, original)
+
+		originalKernel, err := rp.Kernel("copy")
+		if err != nil {
+			t.Error(err)
+			rp.Close()
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, originalKernel)
+
+		if rebuilt, err := rp.Check(); rebuilt || err != nil {
+			t.Error("unexpected rebuild", err)
+		}
+
+		write("__kernel void bad(__global float* a) { a[0] = x; }\n", start.Add(time.Second))
+		if rebuilt, err := rp.Check(); !rebuilt || err == nil {
+			t.Error("expected failed rebuild")
+		}
+		if p, err := rp.Program(); rp.Err() == nil || err != nil || p != original {
+			t.Error("failed rebuild replaced the program")
+		} else {
+			p.Release()
+		}
+
+		write(indexKernel, start.Add(2*time.Second))
+		if rebuilt, err := rp.Check(); !rebuilt || err != nil {
+			t.Error("expected rebuild", err)
+		}
+		if p, err := rp.Program(); rp.Err() != nil || err != nil || p == original {
+			t.Error("rebuild did not replace the program")
+		} else {
+			p.Release()
+		}
+		if k, err := rp.Kernel("index"); err != nil {
+			t.Error(err)
+		} else {
+			toRelease = append(toRelease, k)
+		}
+
+		// Every call returns its own instance, so arguments can be set
+		// concurrently, which -race checks.
+		buffer, err := ctx.CreateDeviceBuffer(4*64, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+		} else {
+			toRelease = append(toRelease, buffer)
+			instances := make([]*Kernel, 8)
+			var wg sync.WaitGroup
+			for i := range instances {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					k, err := rp.Kernel("index")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					instances[i] = k
+					err = k.SetArg(0, buffer)
+					if err != nil {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			for i, k := range instances {
+				if k == nil {
+					continue
+				}
+				if i > 0 && k == instances[0] {
+					t.Error("Kernel returned the same instance twice")
+				}
+				toRelease = append(toRelease, k)
+			}
+		}
+
+		// The kernel obtained before the reload has not been released.
+		if count, err := originalKernel.ReferenceCount(); err != nil || count != 1 {
+			t.Error("unexpected reference count", count, err)
+		}
+
+		err = rp.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		err = rp.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		releaseAll(toRelease, t)
+	}
+}
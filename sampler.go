@@ -0,0 +1,91 @@
+package cl11
+
+import (
+	"unsafe"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// A sampler describes how a kernel reads an image, it is passed to kernels as
+// a sampler_t argument.
+type Sampler struct {
+	id clw.Sampler
+
+	// The context the sampler was created on.
+	Context *Context
+
+	// Whether the image coordinates are normalized, in the range [0, 1].
+	NormalizedCoords bool
+
+	// How out of range image coordinates are handled.
+	AddressingMode AddressingMode
+
+	// How the image is filtered when read.
+	FilterMode FilterMode
+}
+
+type (
+	AddressingMode clw.AddressingMode
+	FilterMode     clw.FilterMode
+)
+
+const (
+	AddressNone           = AddressingMode(clw.AddressNone)
+	AddressClampToEdge    = AddressingMode(clw.AddressClampToEdge)
+	AddressClamp          = AddressingMode(clw.AddressClamp)
+	AddressRepeat         = AddressingMode(clw.AddressRepeat)
+	AddressMirroredRepeat = AddressingMode(clw.AddressMirroredRepeat)
+)
+
+const (
+	FilterNearest = FilterMode(clw.FilterNearest)
+	FilterLinear  = FilterMode(clw.FilterLinear)
+)
+
+// Creates a sampler object.
+func (c *Context) CreateSampler(normalizedCoords bool, am AddressingMode, fm FilterMode) (*Sampler, error) {
+
+	normalized := clw.False
+	if normalizedCoords {
+		normalized = clw.True
+	}
+
+	sampler, err := clw.CreateSampler(c.id, normalized, clw.AddressingMode(am), clw.FilterMode(fm))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sampler{
+		id:               sampler,
+		Context:          c,
+		NormalizedCoords: normalizedCoords,
+		AddressingMode:   am,
+		FilterMode:       fm,
+	}, nil
+}
+
+// Increments the sampler reference count.
+func (s *Sampler) Retain() error {
+	return clw.RetainSampler(s.id)
+}
+
+// Decrements the sampler reference count.
+//
+// The sampler object is deleted after the reference count becomes zero and
+// commands queued for execution on a command-queue(s) that use sampler have
+// finished.
+func (s *Sampler) Release() error {
+	return clw.ReleaseSampler(s.id)
+}
+
+// Return the sampler reference count.
+//
+// The reference count returned should be considered immediately stale. It is
+// unsuitable for general use in applications. This feature is provided for
+// identifying memory leaks.
+func (s *Sampler) ReferenceCount() (int, error) {
+	var count clw.Uint
+	err := clw.GetSamplerInfo(s.id, clw.SamplerReferenceCount, clw.Size(unsafe.Sizeof(count)),
+		unsafe.Pointer(&count), nil)
+	return int(count), err
+}
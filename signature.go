@@ -0,0 +1,27 @@
+package cl11
+
+import "github.com/rdwilliamson/cl11/internal/signature"
+
+// The signature of a kernel function as declared in OpenCL C source.
+type KernelSignature = signature.KernelSignature
+
+// A kernel argument as declared in OpenCL C source.
+type KernelArg = signature.KernelArg
+
+// The address space of a kernel argument.
+type AddressSpace = signature.AddressSpace
+
+const (
+	GlobalSpace   = signature.GlobalSpace
+	LocalSpace    = signature.LocalSpace
+	ConstantSpace = signature.ConstantSpace
+	PrivateSpace  = signature.PrivateSpace
+)
+
+// Parses the kernel function signatures declared in OpenCL C source.
+//
+// The source is not preprocessed, kernels declared through macros are not
+// found and types defined through typedef or #define are reported verbatim.
+func ParseKernelSignatures(source []byte) ([]KernelSignature, error) {
+	return signature.ParseKernelSignatures(source)
+}
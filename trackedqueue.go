@@ -0,0 +1,481 @@
+package cl11
+
+import (
+	"math"
+	"sync"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+// A TrackedQueue wraps a command queue, typically an out-of-order one, and adds
+// the events of earlier commands that conflict with a command to its wait
+// list.
+//
+// For every buffer and image it records the last writers and outstanding
+// readers. A command that reads waits for overlapping writes (read after
+// write), and a command that writes waits for overlapping reads and writes
+// (write after read and write after write). Sub-buffers are tracked as byte
+// ranges of their root buffer so overlapping sub-buffers conflict. Images and
+// rectangle copies are tracked as a whole.
+//
+// Kernel arguments are taken from the values last set with SetArg. Buffers
+// passed as const pointers and read only images are reads if the kernel's
+// program was created from source (see ParseKernelSignatures), all other memory
+// arguments are writes. Only commands enqueued through the TrackedQueue are
+// tracked, commands enqueued directly on the wrapped queue are not seen.
+//
+// Commands may be enqueued concurrently. A command is only held up by a
+// blocking call on another goroutine if it conflicts with that command.
+type TrackedQueue struct {
+	queue *CommandQueue
+
+	mu         sync.Mutex
+	accesses   map[clw.Mem][]trackedAccess
+	signatures map[*Kernel]*KernelSignature
+
+	// The sequence number of the last recorded command.
+	seq uint64
+}
+
+// A memory range a command uses.
+type memAccess struct {
+	mem        clw.Mem
+	start, end int64
+	write      bool
+}
+
+type trackedAccess struct {
+	memAccess
+	command *trackedCommand
+}
+
+// The event of a command, released once no access or waiting command refers to
+// it.
+type trackedCommand struct {
+	event *Event
+	refs  int
+
+	// The order in which commands were recorded.
+	seq uint64
+
+	// Whether the event is the caller's, because it could not be retained, and
+	// must not be released.
+	borrowed bool
+
+	// Closed once the command has been enqueued. The event is nil if that
+	// failed.
+	enqueued chan struct{}
+}
+
+// The range used to track whole objects.
+const wholeObject = math.MaxInt64
+
+// Wraps the command queue to track hazards between the commands enqueued
+// through the returned queue.
+func NewTrackedQueue(cq *CommandQueue) *TrackedQueue {
+	return &TrackedQueue{
+		queue:      cq,
+		accesses:   make(map[clw.Mem][]trackedAccess),
+		signatures: make(map[*Kernel]*KernelSignature),
+	}
+}
+
+// Returns the wrapped command queue. Commands enqueued on it directly are not
+// tracked.
+func (tq *TrackedQueue) Queue() *CommandQueue {
+	return tq.queue
+}
+
+// See CommandQueue.Flush.
+func (tq *TrackedQueue) Flush() error {
+	return tq.queue.Flush()
+}
+
+// See CommandQueue.Finish.
+func (tq *TrackedQueue) Finish() error {
+	return tq.queue.Finish()
+}
+
+// See CommandQueue.EnqueueMarker.
+func (tq *TrackedQueue) EnqueueMarker(e *Event) error {
+	return tq.queue.EnqueueMarker(e)
+}
+
+// See CommandQueue.EnqueueWaitForEvents.
+func (tq *TrackedQueue) EnqueueWaitForEvents(waitList []*Event) error {
+	return tq.queue.EnqueueWaitForEvents(waitList)
+}
+
+// See CommandQueue.EnqueueBarrier.
+func (tq *TrackedQueue) EnqueueBarrier() error {
+	return tq.queue.EnqueueBarrier()
+}
+
+// Returns the access of a buffer range as a range of its root buffer.
+func bufferAccess(b *Buffer, offset, size int64, write bool) memAccess {
+	for b.Buffer != nil {
+		offset += b.Origin
+		b = b.Buffer
+	}
+	return memAccess{mem: b.id, start: offset, end: offset + size, write: write}
+}
+
+func wholeBufferAccess(b *Buffer, write bool) memAccess {
+	return bufferAccess(b, 0, b.Size, write)
+}
+
+func imageAccess(i *Image, write bool) memAccess {
+	return memAccess{mem: i.id, start: 0, end: wholeObject, write: write}
+}
+
+// Enqueues a command with the events of conflicting earlier commands added to
+// the wait list and records its accesses.
+//
+// The accesses are recorded before the command is enqueued so the lock is not
+// held during the enqueue, which may block. Conflicting commands that are
+// still being enqueued by another goroutine are waited for, only they can
+// delay the command.
+//
+// If the command is enqueued but the caller's event can not be retained, the
+// command is tracked with the caller's event, which must then not be released
+// before the command completes, and the error is returned.
+func (tq *TrackedQueue) enqueue(accesses []memAccess, waitList []*Event, e *Event,
+	enqueue func(waitList []*Event, e *Event) error) error {
+
+	// The enqueuing goroutine holds a reference until the command is enqueued.
+	command := &trackedCommand{refs: 1, enqueued: make(chan struct{})}
+
+	tq.mu.Lock()
+	tq.seq++
+	command.seq = tq.seq
+	hazards := tq.hazards(accesses)
+	tq.record(accesses, command)
+	tq.mu.Unlock()
+
+	waitList = waitList[:len(waitList):len(waitList)]
+	for _, hazard := range hazards {
+		<-hazard.enqueued
+		if hazard.event != nil {
+			waitList = append(waitList, hazard.event)
+		}
+	}
+
+	event := e
+	if event == nil {
+		event = &Event{}
+	}
+
+	err := enqueue(waitList, event)
+	enqueued := err == nil
+	if enqueued && e != nil {
+		err = e.Retain()
+	}
+
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	if enqueued {
+		// A copy since callers may reuse their event for another command. It
+		// owns the reference of an event created here.
+		command.event = &Event{id: event.id, Context: event.Context, CommandType: event.CommandType,
+			CommandQueue: event.CommandQueue}
+		command.borrowed = err != nil
+		tq.forgetCovered(accesses, command)
+	} else {
+		tq.forget(command)
+	}
+	close(command.enqueued)
+
+	for _, hazard := range hazards {
+		tq.releaseCommand(hazard)
+	}
+	tq.releaseCommand(command)
+
+	return err
+}
+
+// Returns the unfinished commands that conflict with the accesses. Each has been
+// referenced and must be released by the caller.
+func (tq *TrackedQueue) hazards(accesses []memAccess) []*trackedCommand {
+
+	var commands []*trackedCommand
+	seen := make(map[*trackedCommand]bool)
+
+	for _, a := range accesses {
+		tracked := tq.accesses[a.mem]
+		kept := tracked[:0]
+		for _, t := range tracked {
+
+			// Forget commands that have finished.
+			if t.command.finished() {
+				tq.releaseCommand(t.command)
+				continue
+			}
+			kept = append(kept, t)
+
+			if (a.write || t.write) && a.start < t.end && t.start < a.end && !seen[t.command] {
+				seen[t.command] = true
+				t.command.refs++
+				commands = append(commands, t.command)
+			}
+		}
+		tq.accesses[a.mem] = kept
+	}
+
+	return commands
+}
+
+// Reports whether the command has been enqueued and has completed. Must be
+// called with the lock held.
+func (command *trackedCommand) finished() bool {
+	if command.event == nil {
+		return false
+	}
+	status, eventErr, err := command.event.Status()
+	return err == nil && eventErr == nil && status == Complete
+}
+
+// Records the command's accesses.
+func (tq *TrackedQueue) record(accesses []memAccess, command *trackedCommand) {
+	for _, a := range accesses {
+		command.refs++
+		tq.accesses[a.mem] = append(tq.accesses[a.mem], trackedAccess{a, command})
+	}
+}
+
+// Forgets the accesses of earlier commands that the command's writes
+// completely cover, since anything conflicting with them also conflicts with
+// the write, which waited on them. Accesses recorded after the command, while
+// it was being enqueued, are kept since the write did not wait on them.
+func (tq *TrackedQueue) forgetCovered(accesses []memAccess, command *trackedCommand) {
+	for _, a := range accesses {
+		if !a.write {
+			continue
+		}
+		tracked := tq.accesses[a.mem]
+		kept := tracked[:0]
+		for _, t := range tracked {
+			if a.start <= t.start && t.end <= a.end && t.command.seq < command.seq {
+				tq.releaseCommand(t.command)
+				continue
+			}
+			kept = append(kept, t)
+		}
+		tq.accesses[a.mem] = kept
+	}
+}
+
+// Forgets the accesses of a command that could not be enqueued.
+func (tq *TrackedQueue) forget(command *trackedCommand) {
+	for mem, tracked := range tq.accesses {
+		kept := tracked[:0]
+		for _, t := range tracked {
+			if t.command == command {
+				tq.releaseCommand(command)
+				continue
+			}
+			kept = append(kept, t)
+		}
+		tq.accesses[mem] = kept
+	}
+}
+
+func (tq *TrackedQueue) releaseCommand(command *trackedCommand) {
+	command.refs--
+	if command.refs == 0 && command.event != nil && !command.borrowed {
+		command.event.Release()
+	}
+}
+
+// Forgets all recorded accesses and releases their events, for example after
+// Finish.
+func (tq *TrackedQueue) Reset() {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	for mem, tracked := range tq.accesses {
+		for _, t := range tracked {
+			tq.releaseCommand(t.command)
+		}
+		delete(tq.accesses, mem)
+	}
+}
+
+// Returns the memory the kernel accesses through its arguments.
+func (tq *TrackedQueue) kernelAccesses(k *Kernel) []memAccess {
+
+	signature, ok := tq.signatures[k]
+	if !ok {
+		signature = k.signature()
+		tq.signatures[k] = signature
+	}
+
+	var accesses []memAccess
+	for i, arg := range k.args {
+		readOnly := signature != nil && i < len(signature.Args) && signature.Args[i].Const
+		switch v := arg.(type) {
+		case *Buffer:
+			accesses = append(accesses, wholeBufferAccess(v, !readOnly))
+		case *Image:
+			accesses = append(accesses, imageAccess(v, !readOnly))
+		}
+	}
+	return accesses
+}
+
+// See CommandQueue.EnqueueNDRangeKernel.
+func (tq *TrackedQueue) EnqueueNDRangeKernel(k *Kernel, globalOffset, globalSize, localSize []int,
+	waitList []*Event, e *Event) error {
+
+	tq.mu.Lock()
+	accesses := tq.kernelAccesses(k)
+	tq.mu.Unlock()
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueNDRangeKernel(k, globalOffset, globalSize, localSize, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueNDRangeKernelChunked. The launches are tracked as a
+// single command.
+func (tq *TrackedQueue) EnqueueNDRangeKernelChunked(k *Kernel, globalOffset, globalSize, localSize []int,
+	maxItems int, waitList []*Event, e *Event) error {
+
+	tq.mu.Lock()
+	accesses := tq.kernelAccesses(k)
+	tq.mu.Unlock()
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueNDRangeKernelChunked(k, globalOffset, globalSize, localSize, maxItems, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueTask.
+func (tq *TrackedQueue) EnqueueTask(k *Kernel, waitList []*Event, e *Event) error {
+
+	tq.mu.Lock()
+	accesses := tq.kernelAccesses(k)
+	tq.mu.Unlock()
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueTask(k, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueNativeKernel. All memory objects are assumed to be
+// written.
+func (tq *TrackedQueue) EnqueueNativeKernel(fn NativeKernel, args []byte, memObjects []*Buffer,
+	waitList []*Event, e *Event) error {
+
+	accesses := make([]memAccess, len(memObjects))
+	for i := range memObjects {
+		accesses[i] = wholeBufferAccess(memObjects[i], true)
+	}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueNativeKernel(fn, args, memObjects, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueCopyBuffer.
+func (tq *TrackedQueue) EnqueueCopyBuffer(src, dst *Buffer, srcOffset, dstOffset, size int64, waitList []*Event,
+	e *Event) error {
+
+	accesses := []memAccess{bufferAccess(src, srcOffset, size, false), bufferAccess(dst, dstOffset, size, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueCopyBuffer(src, dst, srcOffset, dstOffset, size, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueCopyBufferRect.
+func (tq *TrackedQueue) EnqueueCopyBufferRect(src, dst *Buffer, r *Rect, waitList []*Event, e *Event) error {
+
+	accesses := []memAccess{wholeBufferAccess(src, false), wholeBufferAccess(dst, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueCopyBufferRect(src, dst, r, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueMapBuffer. A map for writing is tracked as a write.
+func (tq *TrackedQueue) EnqueueMapBuffer(b *Buffer, bc BlockingCall, flags MapFlags, offset, size int64,
+	waitList []*Event, e *Event) (*MappedBuffer, error) {
+
+	accesses := []memAccess{bufferAccess(b, offset, size, flags&MapWrite != 0)}
+
+	var mb *MappedBuffer
+	err := tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		var err error
+		mb, err = tq.queue.EnqueueMapBuffer(b, bc, flags, offset, size, waitList, e)
+		return err
+	})
+	return mb, err
+}
+
+// See CommandQueue.EnqueueUnmapBuffer. The unmap is tracked as a write of the
+// buffer since the host may have written to the mapping.
+func (tq *TrackedQueue) EnqueueUnmapBuffer(mb *MappedBuffer, waitList []*Event, e *Event) error {
+
+	accesses := []memAccess{wholeBufferAccess(mb.Buffer, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueUnmapBuffer(mb, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueCopyImage.
+func (tq *TrackedQueue) EnqueueCopyImage(src, dst *Image, r *Rect, waitList []*Event, e *Event) error {
+
+	accesses := []memAccess{imageAccess(src, false), imageAccess(dst, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueCopyImage(src, dst, r, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueMapImage.
+func (tq *TrackedQueue) EnqueueMapImage(i *Image, bc BlockingCall, flags MapFlags, r *Rect, waitList []*Event,
+	e *Event) (*MappedImage, error) {
+
+	accesses := []memAccess{imageAccess(i, flags&MapWrite != 0)}
+
+	var mi *MappedImage
+	err := tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		var err error
+		mi, err = tq.queue.EnqueueMapImage(i, bc, flags, r, waitList, e)
+		return err
+	})
+	return mi, err
+}
+
+// See CommandQueue.EnqueueUnmapImage.
+func (tq *TrackedQueue) EnqueueUnmapImage(mi *MappedImage, waitList []*Event, e *Event) error {
+
+	accesses := []memAccess{imageAccess(mi.Image, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueUnmapImage(mi, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueCopyImageToBuffer.
+func (tq *TrackedQueue) EnqueueCopyImageToBuffer(src *Image, dst *Buffer, r *Rect, offset int, waitList []*Event,
+	e *Event) error {
+
+	accesses := []memAccess{imageAccess(src, false), wholeBufferAccess(dst, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueCopyImageToBuffer(src, dst, r, offset, waitList, e)
+	})
+}
+
+// See CommandQueue.EnqueueCopyBufferToImage.
+func (tq *TrackedQueue) EnqueueCopyBufferToImage(src *Buffer, dst *Image, offset int, r *Rect, waitList []*Event,
+	e *Event) error {
+
+	accesses := []memAccess{wholeBufferAccess(src, false), imageAccess(dst, true)}
+
+	return tq.enqueue(accesses, waitList, e, func(waitList []*Event, e *Event) error {
+		return tq.queue.EnqueueCopyBufferToImage(src, dst, offset, r, waitList, e)
+	})
+}
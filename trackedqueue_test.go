@@ -0,0 +1,255 @@
+package cl11
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	clw "github.com/rdwilliamson/clw11"
+)
+
+func TestBufferAccess(t *testing.T) {
+	root := &Buffer{Size: 1024}
+	sub := &Buffer{Size: 256, Buffer: root, Origin: 512}
+	subSub := &Buffer{Size: 64, Buffer: sub, Origin: 32}
+
+	a := bufferAccess(subSub, 8, 16, true)
+	if a.start != 552 || a.end != 568 || !a.write {
+		t.Errorf("got %+v, want [552, 568) write", a)
+	}
+	if a := wholeBufferAccess(sub, false); a.start != 512 || a.end != 768 || a.write {
+		t.Errorf("got %+v, want [512, 768) read", a)
+	}
+}
+
+func TestTrackedQueueEnqueue(t *testing.T) {
+	tq := NewTrackedQueue(&CommandQueue{})
+	var zero clw.Mem
+
+	// A blocks in its enqueue, like a blocking map.
+	aEvent := &Event{CommandType: CommandMapBuffer}
+	aEnqueuing := make(chan struct{})
+	aUnblock := make(chan struct{})
+	aDone := make(chan error)
+	go func() {
+		aDone <- tq.enqueue([]memAccess{{mem: zero, start: 0, end: 100, write: true}}, nil, nil,
+			func(waitList []*Event, e *Event) error {
+				*e = *aEvent
+				close(aEnqueuing)
+				<-aUnblock
+				return nil
+			})
+	}()
+	<-aEnqueuing
+
+	// B does not conflict with A, so it is enqueued while A is blocked.
+	err := tq.enqueue([]memAccess{{mem: zero, start: 100, end: 200, write: true}}, nil, nil,
+		func(waitList []*Event, e *Event) error {
+			if len(waitList) != 0 {
+				t.Error("unexpected wait list", waitList)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// C conflicts with A, so it waits for A to be enqueued and then on A's
+	// event.
+	cWaitList := make(chan []*Event, 1)
+	cDone := make(chan error)
+	go func() {
+		cDone <- tq.enqueue([]memAccess{{mem: zero, start: 50, end: 60, write: false}}, nil, nil,
+			func(waitList []*Event, e *Event) error {
+				cWaitList <- waitList
+				return nil
+			})
+	}()
+	select {
+	case <-cWaitList:
+		t.Fatal("conflicting command enqueued before the command it conflicts with")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(aUnblock)
+	if err := <-aDone; err != nil {
+		t.Error(err)
+	}
+	if err := <-cDone; err != nil {
+		t.Error(err)
+	}
+	waitList := <-cWaitList
+	if len(waitList) != 1 || waitList[0] == aEvent || waitList[0].CommandType != CommandMapBuffer {
+		t.Error("expected a copy of A's event, got", waitList)
+	}
+}
+
+func TestTrackedQueueForgetCovered(t *testing.T) {
+	tq := NewTrackedQueue(&CommandQueue{})
+	var zero clw.Mem
+
+	// A writes X and blocks in its enqueue.
+	aEnqueuing := make(chan struct{})
+	aUnblock := make(chan struct{})
+	aDone := make(chan error)
+	go func() {
+		aDone <- tq.enqueue([]memAccess{{mem: zero, start: 0, end: 100, write: true}}, nil, nil,
+			func(waitList []*Event, e *Event) error {
+				*e = Event{CommandType: CommandMapBuffer}
+				close(aEnqueuing)
+				<-aUnblock
+				return nil
+			})
+	}()
+	<-aEnqueuing
+
+	// B reads X, it is recorded and then waits for A to be enqueued.
+	bDone := make(chan error)
+	go func() {
+		bDone <- tq.enqueue([]memAccess{{mem: zero, start: 0, end: 100, write: false}}, nil, nil,
+			func(waitList []*Event, e *Event) error {
+				*e = Event{CommandType: CommandCopyBuffer}
+				return nil
+			})
+	}()
+	for recorded := false; !recorded; {
+		time.Sleep(time.Millisecond)
+		tq.mu.Lock()
+		recorded = len(tq.accesses[zero]) == 2
+		tq.mu.Unlock()
+	}
+
+	// A's write covers B's read, but was recorded before it.
+	close(aUnblock)
+	if err := <-aDone; err != nil {
+		t.Error(err)
+	}
+	if err := <-bDone; err != nil {
+		t.Error(err)
+	}
+
+	// C writes X, so it must wait on both A and B.
+	err := tq.enqueue([]memAccess{{mem: zero, start: 0, end: 100, write: true}}, nil, nil,
+		func(waitList []*Event, e *Event) error {
+			types := make(map[CommandType]bool)
+			for _, e := range waitList {
+				types[e.CommandType] = true
+			}
+			if len(waitList) != 2 || !types[CommandMapBuffer] || !types[CommandCopyBuffer] {
+				t.Error("expected A's and B's events, got", waitList)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrackedQueue(t *testing.T) {
+	allDevices := getDevices(t)
+	for _, device := range allDevices {
+		t.Log(device.Name, "on", device.Platform.Name)
+
+		var toRelease []Object
+		elements := 1 << 20
+		size := int64(elements * 4)
+
+		ctx, err := CreateContext([]*Device{device}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		toRelease = append(toRelease, ctx)
+
+		cq, err := ctx.CreateCommandQueue(device, QueueOutOfOrderExecution)
+		if err != nil {
+			t.Log("out of order execution not supported:", err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, cq)
+		tq := NewTrackedQueue(cq)
+
+		src, err := ctx.CreateDeviceBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, src)
+
+		dst, err := ctx.CreateHostBuffer(size, MemReadWrite)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, dst)
+
+		program, err := ctx.CreateProgramWithSource([]byte(indexKernel))
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, program)
+
+		err = program.Build([]*Device{device}, "", nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		kernel, err := program.CreateKernel("index")
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+		toRelease = append(toRelease, kernel)
+
+		err = kernel.SetArguments(src)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		// No explicit events, the tracked queue orders the commands.
+		err = tq.EnqueueNDRangeKernel(kernel, nil, []int{elements}, nil, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		err = tq.EnqueueCopyBuffer(src, dst, 0, 0, size, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		mapped, err := tq.EnqueueMapBuffer(dst, Blocking, MapRead, 0, size, nil, nil)
+		if err != nil {
+			t.Error(err)
+			releaseAll(toRelease, t)
+			continue
+		}
+
+		b := mapped.Bytes()
+		for i := 0; i < elements; i++ {
+			if v := binary.LittleEndian.Uint32(b[i*4:]); v != uint32(i) {
+				t.Errorf("element %d is %d", i, v)
+				break
+			}
+		}
+
+		err = tq.EnqueueUnmapBuffer(mapped, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		err = tq.Finish()
+		if err != nil {
+			t.Error(err)
+		}
+		tq.Reset()
+
+		releaseAll(toRelease, t)
+	}
+}